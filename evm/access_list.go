@@ -0,0 +1,144 @@
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// AccessListResult is the output of an access list generation
+type AccessListResult struct {
+	AccessList     types.AccessList
+	GasUsed        uint64 // gas used by the message with the access list
+	GasUsedWithout uint64 // gas used by the message without any access list
+	Err            error  // execution error of the message with the access list
+}
+
+// CreateAccessList executes the message as a call and records all the
+// accounts and storage slots it touches, in the same way as
+// eth_createAccessList. The sender, the recipient and the precompiles are
+// excluded unless their storage is accessed, as they're always warm. Since
+// the access list can change the execution, the message is re-executed with
+// the recorded list until it's stable. As with geth, only the accesses of
+// the opcodes are recorded, e.g. the coinbase credited with the tip isn't.
+//
+// Note that goevm only charges for warm accesses, so the gas used with the
+// access list only differs by its intrinsic cost.
+func CreateAccessList(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message) (*AccessListResult, error) {
+	// The recipient of a contract creation is derived from the state nonce
+	to := msg.To
+	if to == nil {
+		var nonce uint64
+		n, err := storage.GetNonce(msg.From)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nonce = *n
+		}
+		address := crypto.CreateAddress(msg.From, nonce)
+		to = &address
+	}
+	rules := config.Rules(new(big.Int).SetUint64(block.Number), true, block.Time)
+	excluded := map[common.Address]struct{}{msg.From: {}, *to: {}}
+	if rules.IsShanghai {
+		excluded[block.Coinbase] = struct{}{}
+	}
+	for _, address := range vm.ActivePrecompiles(rules) {
+		excluded[address] = struct{}{}
+	}
+
+	var (
+		accessList = normaliseAccessList(msg.AccessList, excluded)
+		result     *ExecutionResult
+	)
+	for {
+		call := *msg
+		call.AccessList = accessList
+
+		recorder := newAccessRecorder(storage, true)
+		var err error
+		result, err = Call(config, block, recorder, &call, nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Merge the recorded accesses with the list used for the execution
+		recorded := append(recorder.accessList(), accessList...)
+		recorded = normaliseAccessList(recorded, excluded)
+		if accessListEqual(recorded, accessList) {
+			break
+		}
+		accessList = recorded
+	}
+
+	call := *msg
+	call.AccessList = nil
+	without, err := Call(config, block, storage, &call, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessListResult{
+		AccessList:     accessList,
+		GasUsed:        result.UsedGas,
+		GasUsedWithout: without.UsedGas,
+		Err:            result.Err,
+	}, nil
+}
+
+// accessList returns the recorded accounts and slots as an access list
+func (r *accessRecorder) accessList() types.AccessList {
+	list := make(types.AccessList, 0, len(r.accounts))
+	for address := range r.accounts {
+		keys := make([]common.Hash, 0, len(r.slots[address]))
+		for key := range r.slots[address] {
+			keys = append(keys, key)
+		}
+		list = append(list, types.AccessTuple{Address: address, StorageKeys: keys})
+	}
+	return list
+}
+
+// normaliseAccessList merges the duplicate entries of the list, drops the
+// excluded addresses without any slots and sorts it, so that lists can be
+// compared
+func normaliseAccessList(list types.AccessList, excluded map[common.Address]struct{}) types.AccessList {
+	slots := make(map[common.Address]map[common.Hash]struct{})
+	for _, tuple := range list {
+		if _, ok := slots[tuple.Address]; !ok {
+			slots[tuple.Address] = make(map[common.Hash]struct{})
+		}
+		for _, key := range tuple.StorageKeys {
+			slots[tuple.Address][key] = struct{}{}
+		}
+	}
+
+	normalised := make(types.AccessList, 0, len(slots))
+	for address, keys := range slots {
+		// The slots of the excluded accounts are still cold
+		if _, ok := excluded[address]; ok && len(keys) == 0 {
+			continue
+		}
+		tuple := types.AccessTuple{Address: address, StorageKeys: make([]common.Hash, 0, len(keys))}
+		for key := range keys {
+			tuple.StorageKeys = append(tuple.StorageKeys, key)
+		}
+		slices.SortFunc(tuple.StorageKeys, func(a, b common.Hash) int { return bytes.Compare(a[:], b[:]) })
+		normalised = append(normalised, tuple)
+	}
+	slices.SortFunc(normalised, func(a, b types.AccessTuple) int { return bytes.Compare(a.Address[:], b.Address[:]) })
+	return normalised
+}
+
+// accessListEqual returns whether the normalised lists are equal
+func accessListEqual(a, b types.AccessList) bool {
+	return slices.EqualFunc(a, b, func(x, y types.AccessTuple) bool {
+		return x.Address == y.Address && slices.Equal(x.StorageKeys, y.StorageKeys)
+	})
+}
@@ -0,0 +1,77 @@
+package evm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// opcodeObserver is implemented by the storages which tell the accesses made
+// by the opcodes apart from the ones made by the state transition (e.g. the
+// tip payment). The interpreter notifies them whenever an opcode starts and
+// stops running.
+type opcodeObserver interface {
+	observeOpcode(running bool)
+}
+
+// accessRecorder wraps a storage and records all the accounts and storage
+// slots which are read through it, or only the ones read by the opcodes.
+// Existence checks aren't recorded, as the empty accounts touched by a
+// message are looked up once it's done.
+type accessRecorder struct {
+	Storage
+
+	opcodesOnly bool // whether the reads of the state transition are left out
+	running     bool // whether an opcode is running
+
+	accounts map[common.Address]struct{}
+	slots    map[common.Address]map[common.Hash]struct{}
+}
+
+func newAccessRecorder(storage Storage, opcodesOnly bool) *accessRecorder {
+	return &accessRecorder{
+		Storage:     storage,
+		opcodesOnly: opcodesOnly,
+		accounts:    make(map[common.Address]struct{}),
+		slots:       make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+func (r *accessRecorder) observeOpcode(running bool) {
+	r.running = running
+}
+
+// recordAccount records the account, unless it's read by the state
+// transition and only the opcodes are recorded
+func (r *accessRecorder) recordAccount(address common.Address) bool {
+	if r.opcodesOnly && !r.running {
+		return false
+	}
+	r.accounts[address] = struct{}{}
+	return true
+}
+
+func (r *accessRecorder) GetBalance(address common.Address) (*uint256.Int, error) {
+	r.recordAccount(address)
+	return r.Storage.GetBalance(address)
+}
+
+func (r *accessRecorder) GetNonce(address common.Address) (*uint64, error) {
+	r.recordAccount(address)
+	return r.Storage.GetNonce(address)
+}
+
+func (r *accessRecorder) GetCode(address common.Address) ([]byte, error) {
+	r.recordAccount(address)
+	return r.Storage.GetCode(address)
+}
+
+func (r *accessRecorder) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	if !r.recordAccount(address) {
+		return r.Storage.GetState(address, key)
+	}
+	if _, ok := r.slots[address]; !ok {
+		r.slots[address] = make(map[common.Hash]struct{})
+	}
+	r.slots[address][key] = struct{}{}
+	return r.Storage.GetState(address, key)
+}
@@ -0,0 +1,175 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+// BlockProcessor applies all the transactions of a block one after another
+// against a single storage, in the same way a node imports a block
+type BlockProcessor struct {
+	config  *params.ChainConfig
+	storage Storage
+	getHash GetHashFunc
+
+	tracer *Tracer
+}
+
+// ProcessResult contains the outcome of processing a block
+type ProcessResult struct {
+	Receipts    types.Receipts
+	Logs        []*types.Log
+	GasUsed     uint64
+	ReceiptHash common.Hash
+	Bloom       types.Bloom
+	Root        common.Hash // zero if the storage can't compute state roots
+}
+
+func NewBlockProcessor(config *params.ChainConfig, storage Storage, getHash GetHashFunc, tracer *Tracer) *BlockProcessor {
+	return &BlockProcessor{
+		config:  config,
+		storage: storage,
+		getHash: getHash,
+		tracer:  tracer,
+	}
+}
+
+// Process applies the transactions and withdrawals of the block on top of
+// the storage and returns the receipts along with the post state commitments.
+// Any invalid transaction aborts the processing of the block.
+func (p *BlockProcessor) Process(block *types.Block) (*ProcessResult, error) {
+	var (
+		header   = block.Header()
+		ctx      = NewBlockContext(header, p.getHash)
+		signer   = types.MakeSigner(p.config, header.Number, header.Time)
+		gasPool  = header.GasLimit
+		usedGas  uint64
+		receipts = make(types.Receipts, 0, len(block.Transactions()))
+		allLogs  = make([]*types.Log, 0)
+	)
+
+	for i, tx := range block.Transactions() {
+		msg, err := TransactionToMessage(tx, signer, ctx.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		if gasPool < msg.GasLimit {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w: have %d, want %d", i, tx.Hash().Hex(), ErrGasLimitReached, gasPool, msg.GasLimit)
+		}
+
+		result, err := ApplyMessage(p.config, ctx, p.storage, msg, p.tracer)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		gasPool -= result.UsedGas
+		usedGas += result.UsedGas
+
+		receipt := newReceipt(tx, msg, result, usedGas)
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = header.Number
+		receipt.TransactionIndex = uint(i)
+		for _, l := range receipt.Logs {
+			l.TxHash = tx.Hash()
+			l.TxIndex = uint(i)
+			l.BlockHash = block.Hash()
+			l.Index = uint(len(allLogs))
+			allLogs = append(allLogs, l)
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		receipts = append(receipts, receipt)
+
+		if committer, ok := p.storage.(Committer); ok {
+			if _, err := committer.Commit(); err != nil {
+				return nil, fmt.Errorf("failed to commit state of tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+			}
+		}
+
+		log.Info("Applied transaction", "index", i, "hash", tx.Hash(), "status", receipt.Status, "gas", result.UsedGas, "err", result.Err)
+	}
+
+	// Process the withdrawals, the amount is denominated in gwei
+	for _, w := range block.Withdrawals() {
+		amount := new(uint256.Int).Mul(uint256.NewInt(w.Amount), uint256.NewInt(params.GWei))
+		if err := addBalance(p.storage, w.Address, amount); err != nil {
+			return nil, &StorageError{Op: "withdrawal", Address: w.Address, Err: err}
+		}
+	}
+
+	result := &ProcessResult{
+		Receipts:    receipts,
+		Logs:        allLogs,
+		GasUsed:     usedGas,
+		ReceiptHash: types.DeriveSha(receipts, trie.NewStackTrie(nil)),
+		Bloom:       types.CreateBloom(receipts),
+	}
+	if committer, ok := p.storage.(Committer); ok {
+		root, err := committer.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("failed to commit state: %w", err)
+		}
+		result.Root = root
+	} else if rooter, ok := p.storage.(StateRooter); ok {
+		root, err := rooter.StateRoot()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute state root: %w", err)
+		}
+		result.Root = root
+	}
+
+	log.Info("Processed block", "number", header.Number, "txs", len(receipts), "gas used", usedGas, "root", result.Root)
+	return result, nil
+}
+
+// ValidateState compares the result of processing a block against the
+// commitments in its header
+func ValidateState(header *types.Header, result *ProcessResult) error {
+	if header.GasUsed != result.GasUsed {
+		return fmt.Errorf("invalid gas used (remote: %d local: %d)", header.GasUsed, result.GasUsed)
+	}
+	if header.Bloom != result.Bloom {
+		return fmt.Errorf("invalid bloom (remote: %x  local: %x)", header.Bloom, result.Bloom)
+	}
+	if header.ReceiptHash != result.ReceiptHash {
+		return fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", header.ReceiptHash, result.ReceiptHash)
+	}
+	if result.Root == (common.Hash{}) {
+		log.Warn("Skipping state root validation, storage doesn't support state roots")
+		return nil
+	}
+	if header.Root != result.Root {
+		return fmt.Errorf("invalid merkle root (remote: %x local: %x)", header.Root, result.Root)
+	}
+	return nil
+}
+
+// newReceipt creates the receipt of a transaction. It assumes a post
+// byzantium block, i.e. the status is used instead of the intermediate root.
+func newReceipt(tx *types.Transaction, msg *Message, result *ExecutionResult, cumulativeGasUsed uint64) *types.Receipt {
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		CumulativeGasUsed: cumulativeGasUsed,
+		TxHash:            tx.Hash(),
+		GasUsed:           result.UsedGas,
+		EffectiveGasPrice: msg.GasPrice.ToBig(),
+		Logs:              result.Logs,
+	}
+	if receipt.Logs == nil {
+		receipt.Logs = []*types.Log{}
+	}
+	if result.Failed() {
+		receipt.Status = types.ReceiptStatusFailed
+	} else {
+		receipt.Status = types.ReceiptStatusSuccessful
+	}
+	if msg.To == nil {
+		receipt.ContractAddress = crypto.CreateAddress(msg.From, msg.Nonce)
+	}
+	return receipt
+}
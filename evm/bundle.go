@@ -0,0 +1,60 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// BundleResult contains the outcome of every executed message of a bundle
+type BundleResult struct {
+	Results []*BundleMessageResult
+	Aborted bool // whether a failed message stopped the execution of the bundle
+}
+
+// BundleMessageResult is the outcome of a single message of a bundle
+type BundleMessageResult struct {
+	*ExecutionResult
+	StateDiff *StateDiff // changes made by the message on top of the previous ones
+}
+
+// SimulateBundle applies the messages one after another on an overlay on top
+// of the storage, so that every message sees the state left by the previous
+// ones while the storage is never written. If abortOnFailure is set, the
+// bundle stops at the first message which fails or reverts.
+//
+// Invalid messages (e.g. nonce too low) abort the bundle with an error.
+func SimulateBundle(config *params.ChainConfig, block BlockContext, storage Storage, msgs []*Message, abortOnFailure bool, tracer *Tracer) (*BundleResult, error) {
+	overlay := NewOverlayStorage(storage, tracer)
+	bundle := &BundleResult{Results: make([]*BundleMessageResult, 0, len(msgs))}
+
+	var logIndex uint
+	for i, msg := range msgs {
+		recorder := newStateRecorder(overlay)
+		result, err := ApplyMessage(config, block, recorder, msg, tracer)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply message %d: %w", i, err)
+		}
+		for _, l := range result.Logs {
+			l.TxIndex = uint(i)
+			l.Index = logIndex
+			logIndex++
+		}
+		diff, err := recorder.diff()
+		if err != nil {
+			return nil, fmt.Errorf("could not diff message %d: %w", i, err)
+		}
+		bundle.Results = append(bundle.Results, &BundleMessageResult{
+			ExecutionResult: result,
+			StateDiff:       diff,
+		})
+
+		log.Info("Applied bundle message", "index", i, "gas", result.UsedGas, "err", result.Err)
+		if result.Failed() && abortOnFailure {
+			bundle.Aborted = true
+			break
+		}
+	}
+	return bundle, nil
+}
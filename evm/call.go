@@ -0,0 +1,167 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// OverrideAccount specifies the fields of an account to be overridden for a
+// call. It follows the format of geth's eth_call state overrides.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64             `json:"nonce"`
+	Code      *hexutil.Bytes              `json:"code"`
+	Balance   *hexutil.Big                `json:"balance"`
+	State     map[common.Hash]common.Hash `json:"state"`     // replaces the whole storage
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff"` // replaces only the given slots
+}
+
+// StateOverride is the collection of overridden accounts
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply applies the overrides on top of the overlay
+func (diff StateOverride) Apply(overlay *OverlayStorage) error {
+	for address, account := range diff {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", address.Hex())
+		}
+		if err := overlay.CreateAccount(address); err != nil {
+			return err
+		}
+		if account.Nonce != nil {
+			if err := overlay.SetNonce(address, uint64(*account.Nonce)); err != nil {
+				return err
+			}
+		}
+		if account.Code != nil {
+			if err := overlay.SetCode(address, *account.Code); err != nil {
+				return err
+			}
+		}
+		if account.Balance != nil {
+			balance, overflow := uint256.FromBig(account.Balance.ToInt())
+			if overflow {
+				return fmt.Errorf("balance of account %s overflows", address.Hex())
+			}
+			if err := overlay.SetBalance(address, balance); err != nil {
+				return err
+			}
+		}
+		if account.State != nil {
+			overlay.clearState(address)
+			for key, value := range account.State {
+				if err := overlay.SetState(address, key, value); err != nil {
+					return err
+				}
+			}
+		}
+		for key, value := range account.StateDiff {
+			if err := overlay.SetState(address, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BlockOverrides specifies the fields of the block context to be overridden
+// for a call
+type BlockOverrides struct {
+	Number   *hexutil.Uint64 `json:"number"`
+	Time     *hexutil.Uint64 `json:"time"`
+	GasLimit *hexutil.Uint64 `json:"gasLimit"`
+	Coinbase *common.Address `json:"coinbase"`
+	BaseFee  *hexutil.Big    `json:"baseFee"`
+}
+
+// Apply overrides the fields of the block context
+func (diff *BlockOverrides) Apply(block *BlockContext) error {
+	if diff == nil {
+		return nil
+	}
+	if diff.Number != nil {
+		block.Number = uint64(*diff.Number)
+	}
+	if diff.Time != nil {
+		block.Time = uint64(*diff.Time)
+	}
+	if diff.GasLimit != nil {
+		block.GasLimit = uint64(*diff.GasLimit)
+	}
+	if diff.Coinbase != nil {
+		block.Coinbase = *diff.Coinbase
+	}
+	if diff.BaseFee != nil {
+		baseFee, overflow := uint256.FromBig(diff.BaseFee.ToInt())
+		if overflow {
+			return fmt.Errorf("base fee overflows")
+		}
+		block.BaseFee = baseFee
+	}
+	return nil
+}
+
+// Call executes the message in the same way as eth_call. The state and block
+// overrides are applied on top of the storage in an overlay, so the storage
+// is never written. The nonce of the sender isn't checked and zero gas prices
+// are allowed.
+func Call(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, stateOverrides StateOverride, blockOverrides *BlockOverrides, tracer *Tracer) (*ExecutionResult, error) {
+	result, _, err := callMessage(config, block, storage, msg, stateOverrides, blockOverrides, false, tracer)
+	return result, err
+}
+
+// CallWithStateDiff executes the message like Call and also returns the
+// state changes it made on top of the overrides
+func CallWithStateDiff(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, stateOverrides StateOverride, blockOverrides *BlockOverrides, tracer *Tracer) (*ExecutionResult, *StateDiff, error) {
+	return callMessage(config, block, storage, msg, stateOverrides, blockOverrides, true, tracer)
+}
+
+func callMessage(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, stateOverrides StateOverride, blockOverrides *BlockOverrides, recordDiff bool, tracer *Tracer) (*ExecutionResult, *StateDiff, error) {
+	// Apply the overrides before attaching the tracer so that they don't
+	// show up as writes in the trace
+	overlay := NewOverlayStorage(storage, nil)
+	if err := stateOverrides.Apply(overlay); err != nil {
+		return nil, nil, err
+	}
+	overlay.tracer = tracer
+	if err := blockOverrides.Apply(&block); err != nil {
+		return nil, nil, err
+	}
+
+	call := *msg
+	call.SkipAccountChecks = true
+	if call.Value == nil {
+		call.Value = new(uint256.Int)
+	}
+	if call.GasPrice == nil {
+		call.GasPrice = new(uint256.Int)
+	}
+	if call.GasFeeCap == nil {
+		call.GasFeeCap = call.GasPrice
+	}
+	if call.GasTipCap == nil {
+		call.GasTipCap = call.GasPrice
+	}
+	if call.GasLimit == 0 {
+		call.GasLimit = block.GasLimit
+	}
+	if !recordDiff {
+		result, err := ApplyMessage(config, block, overlay, &call, tracer)
+		return result, nil, err
+	}
+
+	// Record the writes on top of the overrides, so that they aren't part of the diff
+	recorder := newStateRecorder(overlay)
+	result, err := ApplyMessage(config, block, recorder, &call, tracer)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff, err := recorder.diff()
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, diff, nil
+}
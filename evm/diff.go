@@ -0,0 +1,250 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// diffContextSize is the number of steps preceding a divergence which are
+// reported along with it
+const diffContextSize = 5
+
+// Divergence describes the first step at which two executions differ
+type Divergence struct {
+	Step    int       // index of the diverging step
+	Field   string    // first field which differs (pc, opcode, gas, stack, memory, storage or steps)
+	Local   *StepLog  // step executed by goevm, nil if goevm stopped earlier
+	Remote  *StepLog  // step executed by geth, nil if geth stopped earlier
+	Context []StepLog // steps preceding the divergence (common to both executions)
+}
+
+// DiffResult contains the outcome of running a message through both goevm
+// and geth's core/vm
+type DiffResult struct {
+	Local       *ExecutionResult
+	Remote      *core.ExecutionResult
+	LocalSteps  []StepLog
+	RemoteSteps []StepLog
+	Divergence  *Divergence // nil if both executions match step by step
+}
+
+// DiffExecution runs the message through goevm and through geth's core/vm on
+// top of the same pre-state and compares both executions step by step. The
+// storage is never modified.
+//
+// The geth state is seeded with all the accounts and slots read by goevm.
+// As both executions follow the same path until they diverge, any value geth
+// reads before the first divergence is seeded as well.
+func DiffExecution(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message) (*DiffResult, error) {
+	// Run goevm on an overlay, recording all the accessed entries
+	recorder := newAccessRecorder(storage, false)
+	tracer := NewStepTracer()
+	local, err := ApplyMessage(config, block, NewOverlayStorage(recorder, nil), msg, tracer)
+	if err != nil {
+		return nil, fmt.Errorf("goevm: %w", err)
+	}
+
+	// Seed the geth state from the untouched storage
+	recorder.accounts[msg.From] = struct{}{}
+	if msg.To != nil {
+		recorder.accounts[*msg.To] = struct{}{}
+	}
+	statedb, err := newGethState(storage, recorder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seed geth state: %w", err)
+	}
+
+	// Run geth with a struct logger
+	structLogger := logger.NewStructLogger(&logger.Config{EnableMemory: true})
+	gethMsg := toGethMessage(msg)
+	blockCtx := toGethBlockContext(block)
+	gethEvm := vm.NewEVM(blockCtx, core.NewEVMTxContext(gethMsg), statedb, config, vm.Config{Tracer: structLogger.Hooks()})
+	structLogger.OnTxStart(&tracing.VMContext{
+		Coinbase:    blockCtx.Coinbase,
+		BlockNumber: blockCtx.BlockNumber,
+		Time:        blockCtx.Time,
+		Random:      blockCtx.Random,
+		GasPrice:    gethMsg.GasPrice,
+		ChainConfig: config,
+		StateDB:     statedb,
+	}, nil, msg.From)
+	remote, err := core.ApplyMessage(gethEvm, gethMsg, new(core.GasPool).AddGas(math.MaxUint64))
+	if err != nil {
+		return nil, fmt.Errorf("geth: %w", err)
+	}
+
+	remoteSteps := make([]StepLog, 0, len(structLogger.StructLogs()))
+	for _, l := range structLogger.StructLogs() {
+		// Only the outermost frame is comparable as goevm doesn't support calls
+		if l.Depth != 1 {
+			continue
+		}
+		remoteSteps = append(remoteSteps, StepLog{
+			Pc:      l.Pc,
+			Op:      OpCode(l.Op),
+			Gas:     l.Gas,
+			Stack:   l.Stack,
+			Memory:  l.Memory,
+			Storage: l.Storage,
+		})
+	}
+
+	return &DiffResult{
+		Local:       local,
+		Remote:      remote,
+		LocalSteps:  tracer.Steps(),
+		RemoteSteps: remoteSteps,
+		Divergence:  compareSteps(tracer.Steps(), remoteSteps),
+	}, nil
+}
+
+// compareSteps returns the first divergence between the two list of steps
+func compareSteps(local, remote []StepLog) *Divergence {
+	for i := 0; i < len(local) || i < len(remote); i++ {
+		var field string
+		switch {
+		case i >= len(local) || i >= len(remote):
+			field = "steps"
+		case local[i].Pc != remote[i].Pc:
+			field = "pc"
+		case local[i].Op != remote[i].Op:
+			field = "opcode"
+		case local[i].Gas != remote[i].Gas:
+			field = "gas"
+		case !equalStacks(local[i].Stack, remote[i].Stack):
+			field = "stack"
+		case !bytes.Equal(local[i].Memory, remote[i].Memory):
+			field = "memory"
+		case !equalStorage(local[i].Storage, remote[i].Storage):
+			field = "storage"
+		default:
+			continue
+		}
+
+		divergence := &Divergence{
+			Step:    i,
+			Field:   field,
+			Context: local[max(0, i-diffContextSize):min(i, len(local))],
+		}
+		if i < len(local) {
+			divergence.Local = &local[i]
+		}
+		if i < len(remote) {
+			divergence.Remote = &remote[i]
+		}
+		return divergence
+	}
+	return nil
+}
+
+func equalStacks(a, b []uint256.Int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStorage(a, b map[common.Hash]common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// newGethState creates an in-memory geth state containing the recorded
+// accounts and slots, with their values read from the storage. The state is
+// committed so that the values are treated as the original ones by geth.
+func newGethState(storage Storage, recorder *accessRecorder) (*state.StateDB, error) {
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	for address := range recorder.accounts {
+		account, err := snapshotAccount(storage, address, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !account.exists {
+			continue
+		}
+		statedb.SetBalance(address, account.balance, tracing.BalanceChangeUnspecified)
+		statedb.SetNonce(address, account.nonce)
+		statedb.SetCode(address, account.code)
+	}
+	for address, keys := range recorder.slots {
+		for key := range keys {
+			value, err := storage.GetState(address, key)
+			if err != nil {
+				return nil, err
+			}
+			statedb.SetState(address, key, value)
+		}
+	}
+	root, err := statedb.Commit(0, false)
+	if err != nil {
+		return nil, err
+	}
+	return state.New(root, db, nil)
+}
+
+func toGethMessage(msg *Message) *core.Message {
+	return &core.Message{
+		To:         msg.To,
+		From:       msg.From,
+		Nonce:      msg.Nonce,
+		Value:      msg.Value.ToBig(),
+		GasLimit:   msg.GasLimit,
+		GasPrice:   msg.GasPrice.ToBig(),
+		GasFeeCap:  msg.GasFeeCap.ToBig(),
+		GasTipCap:  msg.GasTipCap.ToBig(),
+		Data:       msg.Data,
+		AccessList: msg.AccessList,
+	}
+}
+
+func toGethBlockContext(block BlockContext) vm.BlockContext {
+	ctx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash: func(n uint64) common.Hash {
+			if block.GetHash == nil {
+				return common.Hash{}
+			}
+			return block.GetHash(n)
+		},
+		Coinbase:    block.Coinbase,
+		GasLimit:    block.GasLimit,
+		BlockNumber: new(big.Int).SetUint64(block.Number),
+		Time:        block.Time,
+		Difficulty:  new(big.Int),
+		Random:      &common.Hash{},
+	}
+	if block.BaseFee != nil {
+		ctx.BaseFee = block.BaseFee.ToBig()
+	}
+	return ctx
+}
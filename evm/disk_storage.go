@@ -0,0 +1,289 @@
+package evm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+// diskHeadRootKey is the key under which the root of the last committed
+// state is stored
+var diskHeadRootKey = []byte("goevm-head-root")
+
+// DiskStorage represents a writable store persisted in a local leveldb or
+// pebble database. The writes are kept in a geth state until they're
+// committed to the tries, the last committed state is reopened on restart.
+// It's safe for concurrent use, the accesses to the state are serialised.
+type DiskStorage struct {
+	lock    sync.Mutex // guards the state, which isn't safe for concurrent use
+	db      ethdb.Database
+	triedb  *triedb.Database
+	sdb     state.Database
+	statedb *state.StateDB
+	commits uint64 // number of commits since opened
+
+	tracer *Tracer
+}
+
+// NewDiskStorage opens or creates the database at the given path. The engine
+// (leveldb or pebble) of an existing database is detected, otherwise the
+// given one is used (pebble if empty).
+func NewDiskStorage(path string, engine string, tracer *Tracer) (*DiskStorage, error) {
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Type:      engine,
+		Directory: path,
+		Cache:     128,
+		Handles:   256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	trieDb := triedb.NewDatabase(db, triedb.HashDefaults)
+	storage := &DiskStorage{
+		db:     db,
+		triedb: trieDb,
+		sdb:    state.NewDatabaseWithNodeDB(db, trieDb),
+		tracer: tracer,
+	}
+
+	// Reopen the last committed state, or start with an empty one
+	root := types.EmptyRootHash
+	if data, _ := db.Get(diskHeadRootKey); len(data) == common.HashLength {
+		root = common.BytesToHash(data)
+	}
+	if storage.statedb, err = state.New(root, storage.sdb, nil); err != nil {
+		trieDb.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to open committed state %v: %w", root, err)
+	}
+
+	log.Info("Opened disk storage", "path", path, "root", root)
+
+	return storage, nil
+}
+
+func (s *DiskStorage) IsWriteAllowed() bool {
+	return true
+}
+
+// exists returns whether the account exists, the state keeps the first
+// database failure which is reported instead
+func (s *DiskStorage) exists(address common.Address) (bool, error) {
+	exists := s.statedb.Exist(address)
+	return exists, s.statedb.Error()
+}
+
+func (s *DiskStorage) CreateAccount(address common.Address) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.createAccount(address)
+}
+
+// createAccount creates the account if it doesn't exist. The lock must be held.
+func (s *DiskStorage) createAccount(address common.Address) error {
+	if exists, err := s.exists(address); exists || err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureAccountCreation("address", address)
+	}
+	s.statedb.CreateAccount(address)
+	return nil
+}
+
+// DeleteAccount removes the account along with its storage. The deletion is
+// finalised right away, so that the account is gone for the next reads.
+func (s *DiskStorage) DeleteAccount(address common.Address) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if exists, err := s.exists(address); !exists || err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "account", "address", address, "deleted", true)
+	}
+	s.statedb.SelfDestruct(address)
+	s.statedb.Finalise(false)
+	return s.statedb.Error()
+}
+
+func (s *DiskStorage) Exist(address common.Address) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.exists(address)
+}
+
+func (s *DiskStorage) Empty(address common.Address) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	empty := s.statedb.Empty(address)
+	return empty, s.statedb.Error()
+}
+
+func (s *DiskStorage) SetBalance(address common.Address, balance *uint256.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.createAccount(address); err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "balance", "address", address, "old", s.statedb.GetBalance(address).Uint64(), "new", balance.Uint64())
+	}
+	s.statedb.SetBalance(address, balance, tracing.BalanceChangeUnspecified)
+	return nil
+}
+
+func (s *DiskStorage) GetBalance(address common.Address) (*uint256.Int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if exists, err := s.exists(address); !exists || err != nil {
+		return nil, err
+	}
+	balance := s.statedb.GetBalance(address)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "balance", "address", address, "balance", balance.Uint64())
+	}
+	return balance, nil
+}
+
+func (s *DiskStorage) SetNonce(address common.Address, nonce uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.createAccount(address); err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "nonce", "address", address, "old", s.statedb.GetNonce(address), "new", nonce)
+	}
+	s.statedb.SetNonce(address, nonce)
+	return nil
+}
+
+func (s *DiskStorage) GetNonce(address common.Address) (*uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if exists, err := s.exists(address); !exists || err != nil {
+		return nil, err
+	}
+	nonce := s.statedb.GetNonce(address)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", nonce)
+	}
+	return &nonce, nil
+}
+
+func (s *DiskStorage) SetCode(address common.Address, code []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.createAccount(address); err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "code", "address", address, "old", s.statedb.GetCodeHash(address), "new", len(code))
+	}
+	s.statedb.SetCode(address, code)
+	return nil
+}
+
+func (s *DiskStorage) GetCode(address common.Address) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	code := s.statedb.GetCode(address)
+	if err := s.statedb.Error(); err != nil {
+		return nil, err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "code", "address", address, "size", len(code))
+	}
+	return code, nil
+}
+
+func (s *DiskStorage) SetState(address common.Address, key common.Hash, value common.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "state", "address", address, "key", key, "old", s.statedb.GetState(address, key), "new", value)
+	}
+	s.statedb.SetState(address, key, value)
+	return s.statedb.Error()
+}
+
+func (s *DiskStorage) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	value := s.statedb.GetState(address, key)
+	if err := s.statedb.Error(); err != nil {
+		return common.Hash{}, err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "state", "address", address, "key", key, "value", value)
+	}
+	return value, nil
+}
+
+// StateRoot returns the root of the current state, including the writes
+// which haven't been committed yet
+func (s *DiskStorage) StateRoot() (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.statedb.IntermediateRoot(false), nil
+}
+
+// Commit writes the pending changes into the tries, flushes them to the
+// database and records the root as the state to reopen on restart
+func (s *DiskStorage) Commit() (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commits++
+	root, err := s.statedb.Commit(s.commits, false)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.triedb.Commit(root, false); err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.db.Put(diskHeadRootKey, root.Bytes()); err != nil {
+		return common.Hash{}, err
+	}
+
+	// A committed state can't be modified anymore, continue on a fresh one
+	if s.statedb, err = state.New(root, s.sdb, nil); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+// Close commits the pending changes and closes the database
+func (s *DiskStorage) Close() {
+	root, err := s.Commit()
+	if err != nil {
+		log.Error("Unable to commit state", "err", err)
+	}
+	log.Info("Closing disk storage", "root", root, "commits", s.commits)
+	s.triedb.Close()
+	s.db.Close()
+}
@@ -0,0 +1,49 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// List of execution errors which halt the interpreter. These are reported
+// as part of the execution result and don't invalidate the transaction.
+var (
+	ErrOutOfGas          = errors.New("out of gas")
+	ErrInvalidOpCode     = errors.New("invalid opcode")
+	ErrExecutionReverted = errors.New("execution reverted")
+	ErrCodeStoreOutOfGas = errors.New("contract creation code storage out of gas")
+	ErrMaxCodeSize       = errors.New("max code size exceeded")
+)
+
+// List of consensus errors which make a message invalid. A message failing
+// with any of these can never be included in a block.
+var (
+	ErrNonceTooLow       = errors.New("nonce too low")
+	ErrNonceTooHigh      = errors.New("nonce too high")
+	ErrIntrinsicGas      = errors.New("intrinsic gas too low")
+	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
+	ErrFeeCapTooLow      = errors.New("max fee per gas less than block base fee")
+	ErrGasLimitReached   = errors.New("gas limit reached")
+)
+
+// ErrWriteNotAllowed is returned by the read only stores on any write
+var ErrWriteNotAllowed = errors.New("write not allowed on read only storage")
+
+// StorageError halts the interpreter when the storage fails to read or write
+// the state, e.g. if the database is corrupted or the data is missing. Unlike
+// the execution errors, it means the result of the execution is unknown.
+type StorageError struct {
+	Op      string // operation which failed, e.g. SLOAD
+	Address common.Address
+	Err     error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("storage failure in %s of %v: %v", e.Op, e.Address, e.Err)
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
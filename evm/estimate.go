@@ -0,0 +1,137 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// RevertError is returned when the execution reverts, along with the reason
+type RevertError struct {
+	Reason []byte // raw data returned by REVERT
+}
+
+func (e *RevertError) Error() string {
+	if reason, err := abi.UnpackRevert(e.Reason); err == nil {
+		return fmt.Sprintf("%v: %s", ErrExecutionReverted, reason)
+	}
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("%v: %s", ErrExecutionReverted, hexutil.Encode(e.Reason))
+	}
+	return ErrExecutionReverted.Error()
+}
+
+func (e *RevertError) Unwrap() error {
+	return ErrExecutionReverted
+}
+
+// EstimateGas returns the lowest gas limit with which the message executes
+// successfully. It binary searches between the intrinsic gas and the gas
+// limit of the message (or the block if not set), executing the message as a
+// call for every candidate so that the storage is never written.
+//
+// If the message fails even with the highest limit, the revert reason is
+// returned as a RevertError (or the execution error otherwise).
+func EstimateGas(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, overrides StateOverride) (uint64, error) {
+	intrinsic, err := IntrinsicGas(config, block, msg)
+	if err != nil {
+		return 0, err
+	}
+
+	// Determine the highest gas limit which can be used
+	hi := block.GasLimit
+	if msg.GasLimit >= intrinsic {
+		hi = msg.GasLimit
+	}
+	if hi < intrinsic {
+		return 0, fmt.Errorf("%w: have %d, want %d", ErrIntrinsicGas, hi, intrinsic)
+	}
+
+	// Cap the limit by what the sender can afford at the given fee cap
+	feeCap := msg.GasFeeCap
+	if feeCap == nil {
+		feeCap = msg.GasPrice
+	}
+	if feeCap != nil && !feeCap.IsZero() {
+		overlay := NewOverlayStorage(storage, nil)
+		if err := overrides.Apply(overlay); err != nil {
+			return 0, err
+		}
+		available, err := getBalance(overlay, msg.From)
+		if err != nil {
+			return 0, err
+		}
+		if msg.Value != nil {
+			if available.Lt(msg.Value) {
+				return 0, ErrInsufficientFunds
+			}
+			available.Sub(available, msg.Value)
+		}
+		allowance := new(uint256.Int).Div(available, feeCap)
+		if allowance.IsUint64() && hi > allowance.Uint64() {
+			hi = allowance.Uint64()
+		}
+	}
+
+	// Execute with the highest limit first, if it fails there's no point in searching
+	result, err := callWithGas(config, block, storage, msg, overrides, hi)
+	if err != nil {
+		return 0, err
+	}
+	if result.Failed() {
+		if errors.Is(result.Err, ErrExecutionReverted) {
+			return 0, &RevertError{Reason: result.Revert()}
+		}
+		if isOutOfGas(result.Err) {
+			return 0, fmt.Errorf("gas required exceeds allowance (%d)", hi)
+		}
+		return 0, result.Err
+	}
+
+	// The used gas doesn't include the refunded gas, which needs to be
+	// available during the execution. Try an optimistic limit accounting for
+	// the 63/64 rule before searching.
+	lo := intrinsic - 1
+	optimistic := (result.UsedGas + result.RefundedGas + params.CallStipend) * 64 / 63
+	if optimistic < hi {
+		result, err := callWithGas(config, block, storage, msg, overrides, optimistic)
+		if err != nil {
+			return 0, err
+		}
+		if result.Failed() {
+			lo = optimistic
+		} else {
+			hi = optimistic
+		}
+	}
+
+	for lo+1 < hi {
+		mid := (hi + lo) / 2
+		result, err := callWithGas(config, block, storage, msg, overrides, mid)
+		if err != nil {
+			return 0, err
+		}
+		// Reverts can be gas dependent as well, so any failure means more gas is needed
+		if result.Failed() {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// callWithGas runs the message as a call with the given gas limit
+func callWithGas(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, overrides StateOverride, gas uint64) (*ExecutionResult, error) {
+	call := *msg
+	call.GasLimit = gas
+	return Call(config, block, storage, &call, overrides, nil, nil)
+}
+
+func isOutOfGas(err error) bool {
+	return errors.Is(err, ErrOutOfGas) || errors.Is(err, ErrCodeStoreOutOfGas)
+}
@@ -2,6 +2,7 @@ package evm
 
 import (
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/holiman/uint256"
 )
@@ -11,7 +12,9 @@ const IntrinsicGasCost = 21000
 type EVM struct {
 	scope         ScopeContext
 	table         JumpTable
+	block         BlockContext
 	executionOpts *ExecutionOpts
+	journal       *journal
 	tracer        *Tracer
 }
 
@@ -33,6 +36,43 @@ type ExecutionOpts struct {
 	stopFlag   bool
 	revertFlag bool
 	returnData []byte
+	logs       []*types.Log
+}
+
+// GetHashFunc returns the hash of the n'th block, used by the BLOCKHASH opcode
+type GetHashFunc func(uint64) common.Hash
+
+// BlockContext provides the EVM with information about the block in which
+// the execution is happening
+type BlockContext struct {
+	Coinbase common.Address
+	GasLimit uint64
+	Number   uint64
+	Time     uint64
+	BaseFee  *uint256.Int // nil for pre-london blocks
+	GetHash  GetHashFunc  // optional, BLOCKHASH returns zero if not set
+}
+
+// ExecutionResult includes all the output of an execution
+type ExecutionResult struct {
+	UsedGas     uint64 // total gas used, including the intrinsic gas and refunds
+	RefundedGas uint64 // gas refunded to the sender, already deducted from the used gas
+	Err         error  // any error encountered during the execution (e.g. out of gas)
+	ReturnData  []byte // data returned by RETURN or REVERT
+	Logs        []*types.Log
+}
+
+// Failed returns whether the execution was halted by an error or a revert
+func (result *ExecutionResult) Failed() bool {
+	return result.Err != nil
+}
+
+// Revert returns the revert reason if the execution was aborted by REVERT
+func (result *ExecutionResult) Revert() []byte {
+	if result.Err != ErrExecutionReverted {
+		return nil
+	}
+	return common.CopyBytes(result.ReturnData)
 }
 
 func newScopeContext() ScopeContext {
@@ -60,70 +100,209 @@ func NewExecutionOpts(contract common.Address, sender common.Address, value uint
 	}
 }
 
-func NewEVM(storage Storage, opts *ExecutionOpts, tracer *Tracer) *EVM {
+// NewBlockContext creates the block context for executing in the given block
+func NewBlockContext(header *types.Header, getHash GetHashFunc) BlockContext {
+	var baseFee *uint256.Int
+	if header.BaseFee != nil {
+		baseFee = uint256.MustFromBig(header.BaseFee)
+	}
+	return BlockContext{
+		Coinbase: header.Coinbase,
+		GasLimit: header.GasLimit,
+		Number:   header.Number.Uint64(),
+		Time:     header.Time,
+		BaseFee:  baseFee,
+		GetHash:  getHash,
+	}
+}
+
+func NewEVM(block BlockContext, storage Storage, opts *ExecutionOpts, tracer *Tracer) *EVM {
 	sc := newScopeContext()
 	sc.storage = storage
 
 	table := newInstructionSet()
 	return &EVM{
-		sc,
-		table,
-		opts,
-		tracer,
+		scope:         sc,
+		table:         table,
+		block:         block,
+		executionOpts: opts,
+		journal:       newJournal(),
+		tracer:        tracer,
 	}
 }
 
-func (evm *EVM) Run() {
+// Run deducts the flat intrinsic gas cost and executes the code
+func (evm *EVM) Run() *ExecutionResult {
 	log.Info("Starting execution in evm")
-	if evm.tracer != nil {
-		evm.tracer.CaptureTxStart(evm.executionOpts)
-		defer evm.tracer.CaptureTxEnd(evm.executionOpts)
-	}
 
 	// Check for the intrinsic gas cost and deduct it
+	initialGas := evm.executionOpts.gas
 	if evm.executionOpts.gas < IntrinsicGasCost {
 		log.Error("Insufficient gas to run the code", "gas", evm.executionOpts.gas)
-		return
+		return &ExecutionResult{Err: ErrIntrinsicGas}
 	}
 	evm.executionOpts.gas -= IntrinsicGasCost
 
+	result := evm.execute()
+	result.UsedGas = initialGas - evm.executionOpts.gas
+	return result
+}
+
+// execute runs the interpreter loop over the code until it stops, reverts or
+// halts with an error. Storage modifications are rolled back unless the
+// execution succeeds.
+func (evm *EVM) execute() *ExecutionResult {
+	if evm.tracer != nil {
+		evm.tracer.CaptureTxStart(evm.executionOpts)
+		defer evm.tracer.CaptureTxEnd(evm.executionOpts)
+	}
+
+	snapshot := evm.journal.snapshot()
+	observer, _ := evm.scope.storage.(opcodeObserver)
 	for {
 		opcode := evm.GetOp(evm.executionOpts.pc)
-		if op, ok := evm.table[opcode]; ok {
-			cost := op.gas
-			if evm.executionOpts.gas < cost {
-				log.Error("Insufficient gas to run the opcode", "opcode", opcode, "remaining", evm.executionOpts.gas, "required", cost)
-				return
-			}
-			if evm.tracer != nil {
-				evm.tracer.CaptureOpCodeStart(evm.scope, opcode, evm.executionOpts.gas)
-			}
+		op, ok := evm.table[opcode]
+		if !ok {
+			log.Error("Unknown opcode", "opcode", opcode)
+			return evm.halt(snapshot, ErrInvalidOpCode)
+		}
 
-			// Capture memory length before executing the opcode
-			memLength := evm.scope.memory.Len()
+		cost := op.gas
+		if evm.executionOpts.gas < cost {
+			log.Error("Insufficient gas to run the opcode", "opcode", opcode, "remaining", evm.executionOpts.gas, "required", cost)
+			return evm.halt(snapshot, ErrOutOfGas)
+		}
+		if evm.tracer != nil {
+			evm.tracer.CaptureOpCodeStart(evm.scope, evm.executionOpts.pc, opcode, evm.executionOpts.gas)
+		}
 
-			// Call the execute function of the opcode
-			op.execute(evm)
+		// The static cost is charged first, so that opcodes can check their
+		// dynamic costs against the gas left
+		evm.executionOpts.gas -= cost
 
-			// Calculate memory expansion cost (3 per byte)
-			memCost := (evm.scope.memory.Len() - memLength) * 3
-			evm.executionOpts.gas -= cost + memCost
+		// Capture memory length before executing the opcode
+		memLength := evm.scope.memory.Len()
 
-			if evm.tracer != nil {
-				evm.tracer.CaptureOpCodeEnd(evm.scope, evm.executionOpts.gas)
-			}
-		} else {
-			log.Error("Unknown opcode", "opcode", opcode)
+		// Call the execute function of the opcode
+		if observer != nil {
+			observer.observeOpcode(true)
+		}
+		_, err := op.execute(evm)
+		if observer != nil {
+			observer.observeOpcode(false)
+		}
+		if err != nil {
+			return evm.halt(snapshot, err)
+		}
+
+		// Calculate memory expansion cost (3 per byte)
+		memCost := (evm.scope.memory.Len() - memLength) * 3
+		if evm.executionOpts.gas < memCost {
+			log.Error("Insufficient gas to expand memory", "opcode", opcode, "remaining", evm.executionOpts.gas, "required", memCost)
+			return evm.halt(snapshot, ErrOutOfGas)
+		}
+		evm.executionOpts.gas -= memCost
+
+		if evm.tracer != nil {
+			evm.tracer.CaptureOpCodeEnd(evm.scope, evm.executionOpts.gas)
 		}
+
 		evm.executionOpts.pc++
-		if evm.executionOpts.stopFlag || evm.executionOpts.revertFlag {
-			log.Info("Stop or revert called", "return data", evm.executionOpts.returnData)
-			return
+		if evm.executionOpts.stopFlag {
+			log.Info("Stop called", "return data", evm.executionOpts.returnData)
+			return &ExecutionResult{
+				ReturnData: evm.executionOpts.returnData,
+				Logs:       evm.executionOpts.logs,
+			}
+		}
+		if evm.executionOpts.revertFlag {
+			log.Info("Revert called", "return data", evm.executionOpts.returnData)
+			if err := evm.journal.revertToSnapshot(evm.scope.storage, snapshot); err != nil {
+				return evm.halt(snapshot, &StorageError{Op: "REVERT", Address: evm.executionOpts.contract, Err: err})
+			}
+			evm.executionOpts.logs = nil
+			return &ExecutionResult{
+				Err:        ErrExecutionReverted,
+				ReturnData: evm.executionOpts.returnData,
+			}
 		}
-		// decrease gas
 	}
 }
 
+// memoryEnd returns the end of the memory range read by an opcode after
+// checking that the gas left covers expanding the memory to hold it, so that
+// nothing is allocated for a range which can't be paid for. The expansion is
+// charged once the opcode has run, along with the other memory expansions.
+func (evm *EVM) memoryEnd(offset, length *uint256.Int) (uint64, error) {
+	offset64, offsetOverflow := offset.Uint64WithOverflow()
+	length64, lengthOverflow := length.Uint64WithOverflow()
+	end := offset64 + length64
+	if offsetOverflow || lengthOverflow || end < offset64 {
+		return 0, ErrOutOfGas
+	}
+	if current := evm.scope.memory.Len(); end > current && (end-current) > evm.executionOpts.gas/3 {
+		return 0, ErrOutOfGas
+	}
+	return end, nil
+}
+
+// halt stops the execution with an error. It consumes all the remaining gas
+// and rolls back the storage modifications. A failure to roll back replaces
+// the error with a storage error.
+func (evm *EVM) halt(snapshot int, err error) *ExecutionResult {
+	evm.executionOpts.gas = 0
+	evm.executionOpts.logs = nil
+	if revertErr := evm.journal.revertToSnapshot(evm.scope.storage, snapshot); revertErr != nil {
+		log.Error("Unable to roll back storage modifications", "err", revertErr)
+		err = &StorageError{Op: "REVERT", Address: evm.executionOpts.contract, Err: revertErr}
+	}
+	return &ExecutionResult{Err: err}
+}
+
+// transfer moves the value between the given accounts, creating the
+// recipient if it doesn't exist yet. The changes are recorded in the journal.
+// A zero value transfer doesn't create the recipient, it's only touched.
+func (evm *EVM) transfer(from, to common.Address, value *uint256.Int) error {
+	if value.IsZero() {
+		evm.journal.append(touchChange{address: to})
+		return nil
+	}
+	storage := evm.scope.storage
+
+	fromBalance, err := getBalance(storage, from)
+	if err != nil {
+		return &StorageError{Op: "transfer", Address: from, Err: err}
+	}
+	evm.journal.append(balanceChange{address: from, prev: fromBalance})
+	if err := storage.SetBalance(from, new(uint256.Int).Sub(fromBalance, value)); err != nil {
+		return &StorageError{Op: "transfer", Address: from, Err: err}
+	}
+
+	if err := evm.createAccount(to); err != nil {
+		return &StorageError{Op: "transfer", Address: to, Err: err}
+	}
+	toBalance, err := getBalance(storage, to)
+	if err != nil {
+		return &StorageError{Op: "transfer", Address: to, Err: err}
+	}
+	evm.journal.append(balanceChange{address: to, prev: toBalance})
+	if err := storage.SetBalance(to, new(uint256.Int).Add(toBalance, value)); err != nil {
+		return &StorageError{Op: "transfer", Address: to, Err: err}
+	}
+	return nil
+}
+
+// createAccount creates the account if it doesn't exist yet. The creation is
+// recorded in the journal, so that the account is deleted again on a revert.
+func (evm *EVM) createAccount(address common.Address) error {
+	exists, err := evm.scope.storage.Exist(address)
+	if exists || err != nil {
+		return err
+	}
+	evm.journal.append(createChange{address: address})
+	return evm.scope.storage.CreateAccount(address)
+}
+
 func (evm *EVM) GetOp(n uint64) OpCode {
 	if n < uint64(len(evm.executionOpts.code)) {
 		return OpCode(evm.executionOpts.code[n])
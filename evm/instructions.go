@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/holiman/uint256"
 )
 
@@ -212,7 +213,11 @@ func opAddress(evm *EVM) ([]byte, error) {
 func opBalance(evm *EVM) ([]byte, error) {
 	slot := evm.scope.stack.Peek()
 	address := common.Address(slot.Bytes20())
-	slot.Set(evm.scope.storage.GetBalance(address))
+	balance, err := getBalance(evm.scope.storage, address)
+	if err != nil {
+		return nil, &StorageError{Op: "BALANCE", Address: address, Err: err}
+	}
+	slot.Set(balance)
 	return nil, nil
 }
 
@@ -292,6 +297,56 @@ func opCodeCopy(evm *EVM) ([]byte, error) {
 	return nil, nil
 }
 
+func opBlockhash(evm *EVM) ([]byte, error) {
+	num := evm.scope.stack.Peek()
+	num64, overflow := num.Uint64WithOverflow()
+	if overflow {
+		num.Clear()
+		return nil, nil
+	}
+
+	// Only the 256 most recent complete blocks are available
+	var lower uint64
+	if evm.block.Number > 256 {
+		lower = evm.block.Number - 256
+	}
+	if evm.block.GetHash != nil && num64 >= lower && num64 < evm.block.Number {
+		num.SetBytes(evm.block.GetHash(num64).Bytes())
+	} else {
+		num.Clear()
+	}
+	return nil, nil
+}
+
+func opCoinbase(evm *EVM) ([]byte, error) {
+	evm.scope.stack.Push(new(uint256.Int).SetBytes(evm.block.Coinbase.Bytes()))
+	return nil, nil
+}
+
+func opTimestamp(evm *EVM) ([]byte, error) {
+	evm.scope.stack.Push(new(uint256.Int).SetUint64(evm.block.Time))
+	return nil, nil
+}
+
+func opNumber(evm *EVM) ([]byte, error) {
+	evm.scope.stack.Push(new(uint256.Int).SetUint64(evm.block.Number))
+	return nil, nil
+}
+
+func opGasLimit(evm *EVM) ([]byte, error) {
+	evm.scope.stack.Push(new(uint256.Int).SetUint64(evm.block.GasLimit))
+	return nil, nil
+}
+
+func opBaseFee(evm *EVM) ([]byte, error) {
+	baseFee := new(uint256.Int)
+	if evm.block.BaseFee != nil {
+		baseFee.Set(evm.block.BaseFee)
+	}
+	evm.scope.stack.Push(baseFee)
+	return nil, nil
+}
+
 func opPop(evm *EVM) ([]byte, error) {
 	evm.scope.stack.Pop()
 	return nil, nil
@@ -348,14 +403,28 @@ func opMstore8(evm *EVM) ([]byte, error) {
 func opSload(evm *EVM) ([]byte, error) {
 	loc := evm.scope.stack.Peek()
 	hash := common.Hash(loc.Bytes32())
-	val := evm.scope.storage.GetState(evm.executionOpts.contract, hash)
+	val, err := evm.scope.storage.GetState(evm.executionOpts.contract, hash)
+	if err != nil {
+		return nil, &StorageError{Op: "SLOAD", Address: evm.executionOpts.contract, Err: err}
+	}
 	loc.SetBytes(val.Bytes())
 	return nil, nil
 }
 
 func opSStore(evm *EVM) ([]byte, error) {
 	loc, val := evm.scope.stack.Pop(), evm.scope.stack.Pop()
-	evm.scope.storage.SetState(evm.executionOpts.contract, loc.Bytes32(), val.Bytes32())
+	key := common.Hash(loc.Bytes32())
+
+	// Record the previous value so that the write can be reverted
+	prev, err := evm.scope.storage.GetState(evm.executionOpts.contract, key)
+	if err != nil {
+		return nil, &StorageError{Op: "SSTORE", Address: evm.executionOpts.contract, Err: err}
+	}
+	evm.journal.append(storageChange{address: evm.executionOpts.contract, key: key, prev: prev})
+
+	if err := evm.scope.storage.SetState(evm.executionOpts.contract, key, val.Bytes32()); err != nil {
+		return nil, &StorageError{Op: "SSTORE", Address: evm.executionOpts.contract, Err: err}
+	}
 	return nil, nil
 }
 
@@ -425,3 +494,31 @@ func opRevert(evm *EVM) ([]byte, error) {
 	evm.executionOpts.revertFlag = true
 	return nil, nil
 }
+
+func makeLog(size int) executeFn {
+	return func(evm *EVM) ([]byte, error) {
+		offset, length := evm.scope.stack.Pop(), evm.scope.stack.Pop()
+		topics := make([]common.Hash, size)
+		for i := 0; i < size; i++ {
+			topic := evm.scope.stack.Pop()
+			topics[i] = topic.Bytes32()
+		}
+
+		if !length.IsZero() {
+			end, err := evm.memoryEnd(&offset, &length)
+			if err != nil {
+				return nil, err
+			}
+			evm.scope.memory.Resize(end)
+		}
+		data := common.CopyBytes(evm.scope.memory.Load(offset.Uint64(), length.Uint64()))
+
+		evm.executionOpts.logs = append(evm.executionOpts.logs, &types.Log{
+			Address:     evm.executionOpts.contract,
+			Topics:      topics,
+			Data:        data,
+			BlockNumber: evm.block.Number,
+		})
+		return nil, nil
+	}
+}
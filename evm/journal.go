@@ -0,0 +1,122 @@
+package evm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// journalEntry is a single modification done to the storage which can be
+// reverted if the execution fails
+type journalEntry interface {
+	revert(Storage) error
+
+	// dirtied returns the account modified or touched by the entry
+	dirtied() common.Address
+}
+
+// journal keeps track of the modifications done to the storage during an
+// execution so that they can be rolled back on a revert or a failure
+type journal struct {
+	entries []journalEntry
+}
+
+func newJournal() *journal {
+	return &journal{entries: make([]journalEntry, 0)}
+}
+
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+// snapshot returns an identifier of the current state of the journal
+func (j *journal) snapshot() int {
+	return len(j.entries)
+}
+
+// revertToSnapshot undoes all the modifications done after the given
+// snapshot. It stops at the first entry which fails to be reverted, in which
+// case the storage is left partially reverted.
+func (j *journal) revertToSnapshot(storage Storage, snapshot int) error {
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		if err := j.entries[i].revert(storage); err != nil {
+			j.entries = j.entries[:i]
+			return err
+		}
+	}
+	j.entries = j.entries[:snapshot]
+	return nil
+}
+
+// dirtied returns the accounts modified or touched by the entries which
+// haven't been reverted
+func (j *journal) dirtied() map[common.Address]struct{} {
+	dirtied := make(map[common.Address]struct{})
+	for _, entry := range j.entries {
+		dirtied[entry.dirtied()] = struct{}{}
+	}
+	return dirtied
+}
+
+type (
+	storageChange struct {
+		address common.Address
+		key     common.Hash
+		prev    common.Hash
+	}
+	balanceChange struct {
+		address common.Address
+		prev    *uint256.Int
+	}
+	nonceChange struct {
+		address common.Address
+		prev    uint64
+	}
+	createChange struct {
+		address common.Address
+	}
+	// touchChange marks an account as touched without modifying it, e.g.
+	// the recipient of a zero value transfer
+	touchChange struct {
+		address common.Address
+	}
+)
+
+func (ch storageChange) revert(storage Storage) error {
+	return storage.SetState(ch.address, ch.key, ch.prev)
+}
+
+func (ch storageChange) dirtied() common.Address {
+	return ch.address
+}
+
+func (ch balanceChange) revert(storage Storage) error {
+	return storage.SetBalance(ch.address, ch.prev)
+}
+
+func (ch balanceChange) dirtied() common.Address {
+	return ch.address
+}
+
+func (ch nonceChange) revert(storage Storage) error {
+	return storage.SetNonce(ch.address, ch.prev)
+}
+
+func (ch nonceChange) dirtied() common.Address {
+	return ch.address
+}
+
+func (ch createChange) revert(storage Storage) error {
+	return storage.DeleteAccount(ch.address)
+}
+
+func (ch createChange) dirtied() common.Address {
+	return ch.address
+}
+
+func (ch touchChange) revert(Storage) error {
+	return nil
+}
+
+func (ch touchChange) dirtied() common.Address {
+	return ch.address
+}
@@ -53,6 +53,13 @@ func newInstructionSet() JumpTable {
 	table[CODESIZE] = OpCodeOperation{2, opCodesize}
 	table[CODECOPY] = OpCodeOperation{3, opCodeCopy} // only static considered
 
+	table[BLOCKHASH] = OpCodeOperation{20, opBlockhash}
+	table[COINBASE] = OpCodeOperation{2, opCoinbase}
+	table[TIMESTAMP] = OpCodeOperation{2, opTimestamp}
+	table[NUMBER] = OpCodeOperation{2, opNumber}
+	table[GASLIMIT] = OpCodeOperation{2, opGasLimit}
+	table[BASEFEE] = OpCodeOperation{2, opBaseFee}
+
 	table[POP] = OpCodeOperation{2, opPop}
 	table[PUSH0] = OpCodeOperation{2, makePush(0)}
 	for i := 0; i < 32; i++ {
@@ -81,6 +88,11 @@ func newInstructionSet() JumpTable {
 		table[op] = OpCodeOperation{3, makeSwap(i + 1)}
 	}
 
+	for i := 0; i <= 4; i++ {
+		op := LOG0 + OpCode(i)
+		table[op] = OpCodeOperation{uint64(375 * (i + 1)), makeLog(i)} // only static considered
+	}
+
 	table[RETURN] = OpCodeOperation{0, opReturn}
 	table[REVERT] = OpCodeOperation{0, opRevert}
 
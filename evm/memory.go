@@ -36,6 +36,7 @@ func (m *Memory) Load(offset, size uint64) []byte {
 	if offset+size > m.Len() {
 		data := make([]byte, size)
 		copy(data, m.data[offset:])
+		return data
 	}
 
 	return m.data[offset : offset+size]
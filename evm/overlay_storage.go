@@ -0,0 +1,269 @@
+package evm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// overlayAccount is an account which has been modified in the overlay
+type overlayAccount struct {
+	balance *uint256.Int
+	nonce   uint64
+	code    []byte
+}
+
+// OverlayStorage is a writable in-memory layer on top of any storage. Reads
+// fall through to the backing storage unless the entry has been modified
+// and all the writes are kept in memory, the backing storage is never written.
+type OverlayStorage struct {
+	backing  Storage
+	accounts map[common.Address]*overlayAccount // nil for deleted accounts
+	state    map[common.Address]map[common.Hash]common.Hash
+	cleared  map[common.Address]struct{} // accounts whose backing storage is hidden
+
+	tracer *Tracer
+}
+
+func NewOverlayStorage(backing Storage, tracer *Tracer) *OverlayStorage {
+	return &OverlayStorage{
+		backing:  backing,
+		accounts: make(map[common.Address]*overlayAccount),
+		state:    make(map[common.Address]map[common.Hash]common.Hash),
+		cleared:  make(map[common.Address]struct{}),
+		tracer:   tracer,
+	}
+}
+
+// observeOpcode forwards the running opcodes to the backing storage, so that
+// it can observe the accesses of the opcodes through the overlay
+func (s *OverlayStorage) observeOpcode(running bool) {
+	if observer, ok := s.backing.(opcodeObserver); ok {
+		observer.observeOpcode(running)
+	}
+}
+
+func (s *OverlayStorage) IsWriteAllowed() bool {
+	return true
+}
+
+// account returns the overlay account for the address, loading it from the
+// backing storage if it hasn't been modified yet. It returns nil if the
+// account doesn't exist in either of them or has been deleted.
+func (s *OverlayStorage) account(address common.Address) (*overlayAccount, error) {
+	if account, ok := s.accounts[address]; ok {
+		return account, nil
+	}
+	balance, err := s.backing.GetBalance(address)
+	if balance == nil {
+		return nil, err
+	}
+	code, err := s.backing.GetCode(address)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := s.backing.GetNonce(address)
+	if err != nil {
+		return nil, err
+	}
+	account := &overlayAccount{
+		balance: new(uint256.Int).Set(balance),
+		code:    code,
+	}
+	if nonce != nil {
+		account.nonce = *nonce
+	}
+	s.accounts[address] = account
+	return account, nil
+}
+
+func (s *OverlayStorage) CreateAccount(address common.Address) error {
+	_, err := s.getOrNewAccount(address)
+	return err
+}
+
+// getOrNewAccount returns the overlay account for the address, creating it
+// if it doesn't exist
+func (s *OverlayStorage) getOrNewAccount(address common.Address) (*overlayAccount, error) {
+	if account, err := s.account(address); account != nil || err != nil {
+		return account, err
+	}
+	account := &overlayAccount{balance: uint256.NewInt(0)}
+	if s.tracer != nil {
+		s.tracer.CaptureAccountCreation("address", address, "nonce", account.nonce, "balance", account.balance.Uint64())
+	}
+	s.accounts[address] = account
+	return account, nil
+}
+
+// DeleteAccount hides the account and its storage, including the ones in the
+// backing storage
+func (s *OverlayStorage) DeleteAccount(address common.Address) error {
+	if account, err := s.account(address); account == nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "account", "address", address, "deleted", true)
+	}
+	s.accounts[address] = nil
+	s.clearState(address)
+	return nil
+}
+
+func (s *OverlayStorage) Exist(address common.Address) (bool, error) {
+	if account, ok := s.accounts[address]; ok {
+		return account != nil, nil
+	}
+	return s.backing.Exist(address)
+}
+
+func (s *OverlayStorage) Empty(address common.Address) (bool, error) {
+	if account, ok := s.accounts[address]; ok {
+		return account == nil || (account.nonce == 0 && account.balance.IsZero() && len(account.code) == 0), nil
+	}
+	return s.backing.Empty(address)
+}
+
+func (s *OverlayStorage) SetBalance(address common.Address, balance *uint256.Int) error {
+	account, err := s.getOrNewAccount(address)
+	if err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "balance", "address", address, "old", account.balance.Uint64(), "new", balance.Uint64())
+	}
+	account.balance = balance
+	return nil
+}
+
+func (s *OverlayStorage) GetBalance(address common.Address) (*uint256.Int, error) {
+	if account, ok := s.accounts[address]; ok {
+		if account == nil {
+			return nil, nil
+		}
+		return account.balance, nil
+	}
+	return s.backing.GetBalance(address)
+}
+
+func (s *OverlayStorage) SetNonce(address common.Address, nonce uint64) error {
+	account, err := s.getOrNewAccount(address)
+	if err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "nonce", "address", address, "old", account.nonce, "new", nonce)
+	}
+	account.nonce = nonce
+	return nil
+}
+
+func (s *OverlayStorage) GetNonce(address common.Address) (*uint64, error) {
+	if account, ok := s.accounts[address]; ok {
+		if account == nil {
+			return nil, nil
+		}
+		nonce := account.nonce
+		return &nonce, nil
+	}
+	return s.backing.GetNonce(address)
+}
+
+func (s *OverlayStorage) SetCode(address common.Address, code []byte) error {
+	account, err := s.getOrNewAccount(address)
+	if err != nil {
+		return err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "code", "address", address, "old", len(account.code), "new", len(code))
+	}
+	account.code = code
+	return nil
+}
+
+func (s *OverlayStorage) GetCode(address common.Address) ([]byte, error) {
+	if account, ok := s.accounts[address]; ok {
+		if account == nil {
+			return nil, nil
+		}
+		return account.code, nil
+	}
+	return s.backing.GetCode(address)
+}
+
+func (s *OverlayStorage) SetState(address common.Address, key common.Hash, value common.Hash) error {
+	if s.tracer != nil {
+		prev, err := s.GetState(address, key)
+		if err != nil {
+			return err
+		}
+		s.tracer.CaptureStorageWrites("entity", "state", "address", address, "key", key, "old", prev, "new", value)
+	}
+	if _, ok := s.state[address]; !ok {
+		s.state[address] = make(map[common.Hash]common.Hash)
+	}
+	s.state[address][key] = value
+	return nil
+}
+
+func (s *OverlayStorage) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	if state, ok := s.state[address]; ok {
+		if value, ok := state[key]; ok {
+			return value, nil
+		}
+	}
+	if _, ok := s.cleared[address]; ok {
+		return common.Hash{}, nil
+	}
+	return s.backing.GetState(address, key)
+}
+
+// clearState drops all the slots of the account, including the ones in the
+// backing storage which are hidden from then on
+func (s *OverlayStorage) clearState(address common.Address) {
+	delete(s.state, address)
+	s.cleared[address] = struct{}{}
+}
+
+// Diff returns the changes made in the overlay compared to the backing
+// storage. For accounts whose storage has been replaced, only the slots
+// written in the overlay are reported.
+func (s *OverlayStorage) Diff() (*StateDiff, error) {
+	addresses := make(map[common.Address]struct{})
+	for address := range s.accounts {
+		addresses[address] = struct{}{}
+	}
+	for address := range s.state {
+		addresses[address] = struct{}{}
+	}
+
+	diff := newStateDiff()
+	for address := range addresses {
+		keys := make([]common.Hash, 0, len(s.state[address]))
+		for key := range s.state[address] {
+			keys = append(keys, key)
+		}
+		prev, err := snapshotAccount(s.backing, address, keys)
+		if err != nil {
+			return nil, err
+		}
+		current, err := snapshotAccount(s, address, keys)
+		if err != nil {
+			return nil, err
+		}
+		diff.add(address, prev, current)
+	}
+	return diff, nil
+}
+
+// Discard drops all the changes made in the overlay, the reads fall through
+// to the backing storage again
+func (s *OverlayStorage) Discard() {
+	s.accounts = make(map[common.Address]*overlayAccount)
+	s.state = make(map[common.Address]map[common.Hash]common.Hash)
+	s.cleared = make(map[common.Address]struct{})
+}
+
+// Close closes the backing storage
+func (s *OverlayStorage) Close() {
+	s.backing.Close()
+}
@@ -0,0 +1,67 @@
+package evm
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ParallelResult is the outcome of a single message of a parallel simulation
+type ParallelResult struct {
+	*ExecutionResult
+	StateDiff *StateDiff // changes made by the message on top of the storage
+	Err       error      // set if the message couldn't be applied (e.g. nonce too low)
+}
+
+// SimulateParallel applies every message independently on top of the storage
+// using the given number of workers (one per cpu if zero). Each message runs
+// in its own EVM on its own overlay, so the messages don't see each other's
+// changes and the storage is never written. The storage must be safe for
+// concurrent reads. The results are returned in the order of the messages.
+func SimulateParallel(config *params.ChainConfig, block BlockContext, storage Storage, msgs []*Message, workers int) []*ParallelResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, len(msgs))
+
+	var (
+		results = make([]*ParallelResult, len(msgs))
+		tasks   = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				results[i] = simulateIsolated(config, block, storage, msgs[i])
+			}
+		}()
+	}
+	for i := range msgs {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	log.Info("Simulated messages in parallel", "messages", len(msgs), "workers", workers)
+	return results
+}
+
+// simulateIsolated applies the message on a fresh overlay on top of the
+// storage. The tracer isn't safe for concurrent use, hence it's not traced.
+func simulateIsolated(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message) *ParallelResult {
+	recorder := newStateRecorder(NewOverlayStorage(storage, nil))
+	result, err := ApplyMessage(config, block, recorder, msg, nil)
+	if err != nil {
+		return &ParallelResult{Err: fmt.Errorf("could not apply message: %w", err)}
+	}
+	diff, err := recorder.diff()
+	if err != nil {
+		return &ParallelResult{Err: fmt.Errorf("could not diff message: %w", err)}
+	}
+	return &ParallelResult{ExecutionResult: result, StateDiff: diff}
+}
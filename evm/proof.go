@@ -0,0 +1,170 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+// AccountResult contains the merkle proofs of an account and some of its
+// storage slots, in the same format as eth_getProof
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+}
+
+// StorageResult contains the merkle proof of a storage slot
+type StorageResult struct {
+	Key   common.Hash     `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+// proofList collects the encoded trie nodes of a proof in order
+type proofList []hexutil.Bytes
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, common.CopyBytes(value))
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	return errors.New("proof list doesn't support deletion")
+}
+
+// GetProof returns the proofs of the account and the given storage slots
+// against the state root of the opened block. Missing accounts and slots are
+// proven to be absent.
+func (s *RemoteStorage) GetProof(address common.Address, keys []common.Hash) (*AccountResult, error) {
+	reader, err := s.acquireReader()
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseReader(reader)
+
+	accountProof := make(proofList, 0)
+	if err := reader.trie.Prove(crypto.Keccak256(address.Bytes()), &accountProof); err != nil {
+		return nil, fmt.Errorf("failed to prove account %v: %w", address, err)
+	}
+
+	result := &AccountResult{
+		Address:      address,
+		AccountProof: accountProof,
+		Balance:      new(hexutil.Big),
+		CodeHash:     types.EmptyCodeHash,
+		StorageHash:  types.EmptyRootHash,
+		StorageProof: make([]StorageResult, 0, len(keys)),
+	}
+	account, _, err := s.getAccount(address)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil {
+		result.Balance = (*hexutil.Big)(account.Balance.ToBig())
+		result.CodeHash = common.BytesToHash(account.CodeHash)
+		result.Nonce = hexutil.Uint64(account.Nonce)
+		result.StorageHash = account.Root
+	}
+
+	storageTrie, err := s.storageTrie(reader, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		storageProof := make(proofList, 0)
+		if storageTrie != nil {
+			if err := storageTrie.Prove(crypto.Keccak256(key.Bytes()), &storageProof); err != nil {
+				return nil, fmt.Errorf("failed to prove slot %v of %v: %w", key, address, err)
+			}
+		}
+		value, err := s.GetState(address, key)
+		if err != nil {
+			return nil, err
+		}
+		result.StorageProof = append(result.StorageProof, StorageResult{
+			Key:   key,
+			Value: (*hexutil.Big)(value.Big()),
+			Proof: storageProof,
+		})
+	}
+	return result, nil
+}
+
+// VerifyProof checks the proofs of the account and its storage slots against
+// the given state root, and that the proven values match the ones in the
+// result
+func VerifyProof(root common.Hash, result *AccountResult) error {
+	data, err := verifyProof(root, crypto.Keccak256(result.Address.Bytes()), result.AccountProof)
+	if err != nil {
+		return fmt.Errorf("invalid account proof: %w", err)
+	}
+
+	// Missing accounts are reported with the empty values
+	account := &types.StateAccount{Balance: new(uint256.Int), Root: types.EmptyRootHash, CodeHash: types.EmptyCodeHash.Bytes()}
+	if data != nil {
+		if err := rlp.DecodeBytes(data, account); err != nil {
+			return fmt.Errorf("invalid account in proof: %w", err)
+		}
+	}
+	if result.Balance == nil || account.Balance.ToBig().Cmp(result.Balance.ToInt()) != 0 {
+		return fmt.Errorf("invalid balance, proven: %v, got: %v", account.Balance, result.Balance)
+	}
+	if account.Nonce != uint64(result.Nonce) {
+		return fmt.Errorf("invalid nonce, proven: %d, got: %d", account.Nonce, result.Nonce)
+	}
+	if common.BytesToHash(account.CodeHash) != result.CodeHash {
+		return fmt.Errorf("invalid code hash, proven: %x, got: %v", account.CodeHash, result.CodeHash)
+	}
+	if account.Root != result.StorageHash {
+		return fmt.Errorf("invalid storage hash, proven: %v, got: %v", account.Root, result.StorageHash)
+	}
+
+	for _, slot := range result.StorageProof {
+		data, err := verifyProof(account.Root, crypto.Keccak256(slot.Key.Bytes()), slot.Proof)
+		if err != nil {
+			return fmt.Errorf("invalid proof of slot %v: %w", slot.Key, err)
+		}
+		value := new(big.Int)
+		if data != nil {
+			_, content, _, err := rlp.Split(data)
+			if err != nil {
+				return fmt.Errorf("invalid value of slot %v in proof: %w", slot.Key, err)
+			}
+			value.SetBytes(content)
+		}
+		if slot.Value == nil || value.Cmp(slot.Value.ToInt()) != 0 {
+			return fmt.Errorf("invalid value of slot %v, proven: %v, got: %v", slot.Key, value, slot.Value)
+		}
+	}
+	return nil
+}
+
+// verifyProof returns the value of the key proven by the nodes, nil if the
+// key is proven to be absent
+func verifyProof(root common.Hash, key []byte, proof []hexutil.Bytes) ([]byte, error) {
+	// The proof of an empty trie doesn't contain any node
+	if root == types.EmptyRootHash && len(proof) == 0 {
+		return nil, nil
+	}
+	db := memorydb.New()
+	for _, node := range proof {
+		if err := db.Put(crypto.Keccak256(node), node); err != nil {
+			return nil, err
+		}
+	}
+	return trie.VerifyProof(root, key, db)
+}
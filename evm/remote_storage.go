@@ -1,146 +1,641 @@
 package evm
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
 	"github.com/holiman/uint256"
 )
 
 // RemoteStorage represents a disk based store of an existing geth based
-// EVM node (hash based scheme). It acts as an interafce to interact with
-// the underlying data (e.g. state and accounts) from the node.
+// EVM node (leveldb or pebble, hash or path based scheme). It acts as an
+// interface to interact with the underlying data (e.g. state and accounts)
+// from the node. It's safe for concurrent reads, e.g. by many EVMs each
+// writing to their own overlay. Concurrent readers share the caches but
+// read the tries through their own handles.
 type RemoteStorage struct {
-	root    common.Hash    // latest head's root hash
+	header  *types.Header  // header of the block the state is opened at
+	root    common.Hash    // state root of the block
 	db      ethdb.Database // for raw kv interactions
-	statedb state.Database // for accessing storage tries whenever required
-	trie    state.Trie     // for accessing main merkle trie
+	triedb  *triedb.Database
+	statedb state.Database // for accessing the tries whenever required
+
+	snaps *snapshot.Tree    // flat state snapshot, nil if not available
+	snap  snapshot.Snapshot // snapshot layer matching the root, nil if not covered
+
+	witness *stateless.Witness // records the state read, nil if not recording
+
+	// The caches are updated on every read, so they're guarded by the lock
+	// along with the opened state. The lock is never held while reading
+	// from the database, and the tries, which aren't safe for concurrent
+	// use, are checked out by one reader at a time.
+	lock     sync.Mutex
+	epoch    uint64                            // incremented whenever the state is reopened
+	readers  []*trieReader                     // handles opened on the current state
+	idle     []*trieReader                     // handles which aren't in use
+	accounts map[common.Address]cachedAccount  // decoded accounts
+	slots    lru.BasicLRU[slotKey, cachedSlot] // most recently read slots
+	stats    CacheStats
 
 	tracer *Tracer
 }
 
-func NewRemoteStorage(path string, tracer *Tracer) *RemoteStorage {
-	// Open the key value db given the path. We're assuming that it's using leveldb
-	db, err := rawdb.NewLevelDBDatabase(path, 1024, 2000, "", true)
+// trieReader is a handle on the account trie of the opened state along with
+// the storage tries opened through it
+type trieReader struct {
+	epoch        uint64
+	root         common.Hash
+	trie         state.Trie
+	storageTries map[common.Address]state.Trie
+}
+
+// slotCacheSize is the maximum number of slots kept in the cache
+const slotCacheSize = 100_000
+
+// slotKey identifies a storage slot of an account
+type slotKey struct {
+	address common.Address
+	key     common.Hash
+}
+
+// Sources from which the accounts and slots are read
+const (
+	sourceSnapshot = "snapshot"
+	sourceTrie     = "trie"
+)
+
+// cachedAccount is a decoded account along with the source it was read from
+type cachedAccount struct {
+	account *types.StateAccount // nil if missing
+	source  string
+}
+
+// cachedSlot is the value of a slot along with the source it was read from
+type cachedSlot struct {
+	value  common.Hash
+	source string
+}
+
+// CacheStats contains the hit and miss counters of the storage caches and
+// the number of misses served by the snapshot and the tries
+type CacheStats struct {
+	AccountHits   uint64
+	AccountMisses uint64
+	TrieHits      uint64
+	TrieMisses    uint64
+	SlotHits      uint64
+	SlotMisses    uint64
+
+	AccountSnapshotReads uint64
+	AccountTrieReads     uint64
+	SlotSnapshotReads    uint64
+	SlotTrieReads        uint64
+}
+
+// NewRemoteStorage opens the datadir at the state of the latest head
+func NewRemoteStorage(path string, tracer *Tracer) (*RemoteStorage, error) {
+	return NewRemoteStorageAt(path, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), tracer)
+}
+
+// NewRemoteStorageAt opens the datadir at the state of the given block,
+// which is either a canonical block number, a block hash or the latest tag
+func NewRemoteStorageAt(path string, block rpc.BlockNumberOrHash, tracer *Tracer) (*RemoteStorage, error) {
+	// Open the key value db given the path, detecting the database engine
+	db, err := openDatabase(path)
 	if err != nil {
-		log.Error("Error opening leveldb database", "path", path, "err", err)
-		return nil
+		return nil, fmt.Errorf("failed to open database at %s: %w", path, err)
 	}
 
-	// Open the trie database with the kv database and default hash scheme based config
-	trieDb := triedb.NewDatabase(db, triedb.HashDefaults)
-	if trieDb == nil {
-		log.Error("Error opening trie database", "path", path)
-		db.Close()
-		return nil
-	}
+	// Open the trie database using the state scheme of the datadir
+	trieDb := openTrieDatabase(db)
 
 	// Create a state database
 	stateDb := state.NewDatabaseWithNodeDB(db, trieDb)
 
-	// Get the latest head from db
-	latest := rawdb.ReadHeadHeader(db)
-	if latest == nil {
-		log.Error("Unable to query latest header from kv db")
+	// Find the header of the requested block
+	header, err := readHeader(db, block)
+	if err != nil {
+		trieDb.Close()
 		db.Close()
-		return nil
+		return nil, fmt.Errorf("failed to find block %s: %w", block.String(), err)
 	}
 
-	// Open the trie using the latest head's root
-	trie, err := stateDb.OpenTrie(latest.Root)
-	if err != nil {
-		log.Error("Unable to open trie on latest head's root", "root", latest.Root, "number", latest.Number.Uint64(), "hash", latest.Hash(), "err", err)
+	storage := &RemoteStorage{
+		db:      db,
+		triedb:  trieDb,
+		statedb: stateDb,
+		snaps:   openSnapshot(db, trieDb),
+		tracer:  tracer,
+	}
+
+	// Open the trie using the block's root
+	if err := storage.openState(header); err != nil {
+		if storage.snaps != nil {
+			storage.snaps.Release()
+		}
+		trieDb.Close()
 		db.Close()
+		return nil, err
+	}
+
+	log.Info("Opened database", "scheme", trieDb.Scheme(), "number", header.Number.Uint64(), "root", header.Root, "hash", header.Hash(), "snapshot", storage.snap != nil)
+
+	return storage, nil
+}
+
+// openDatabase opens the key value store of the datadir in read only mode.
+// Both leveldb and pebble are supported, the engine is detected from the
+// files present in the directory. The ancient store (freezer) holding the old
+// headers, bodies and receipts is opened along with it if present.
+func openDatabase(path string) (ethdb.Database, error) {
+	engine := rawdb.PreexistingDatabase(path)
+	if engine == "" {
+		return nil, fmt.Errorf("no leveldb or pebble database found at %s", path)
+	}
+	ancient := filepath.Join(path, "ancient")
+	if !common.FileExist(ancient) {
+		log.Warn("Ancient store not found, only the data in the key value store is available", "path", ancient)
+		ancient = ""
+	}
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              engine,
+		Directory:         path,
+		AncientsDirectory: ancient,
+		Cache:             1024,
+		Handles:           2000,
+		ReadOnly:          true,
+	})
+}
+
+// openTrieDatabase opens the trie database in read only mode using the state
+// scheme of the datadir. With the path scheme, only the persisted state and
+// the diff layers of the recent blocks (journaled on shutdown) are readable,
+// as pathdb doesn't serve reads from its state history yet.
+func openTrieDatabase(db ethdb.Database) *triedb.Database {
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		return triedb.NewDatabase(db, &triedb.Config{PathDB: pathdb.ReadOnly})
+	}
+	return triedb.NewDatabase(db, triedb.HashDefaults)
+}
+
+// openSnapshot loads the flat state snapshot of the datadir without ever
+// generating it. The snapshot only covers the head state and the diff layers
+// of the recent blocks, nil is returned if it's missing or unusable.
+func openSnapshot(db ethdb.Database, trieDb *triedb.Database) *snapshot.Tree {
+	head := rawdb.ReadHeadHeader(db)
+	if head == nil {
 		return nil
 	}
+	snaps, err := snapshot.New(snapshot.Config{CacheSize: 16, NoBuild: true}, db, trieDb, head.Root)
+	if err != nil {
+		log.Info("Snapshot not available, reading the state from the tries", "err", err)
+		return nil
+	}
+	return snaps
+}
 
-	log.Info("Opened database using latest head", "number", latest.Number.Uint64(), "root", latest.Root, "hash", latest.Hash())
+// readHeader returns the header of the given block from the database
+func readHeader(db ethdb.Reader, block rpc.BlockNumberOrHash) (*types.Header, error) {
+	if hash, ok := block.Hash(); ok {
+		number := rawdb.ReadHeaderNumber(db, hash)
+		if number == nil {
+			return nil, fmt.Errorf("block %v not found", hash)
+		}
+		header := rawdb.ReadHeader(db, hash, *number)
+		if header == nil {
+			return nil, fmt.Errorf("header of block %v not found", hash)
+		}
+		if block.RequireCanonical && rawdb.ReadCanonicalHash(db, *number) != hash {
+			return nil, fmt.Errorf("block %v is not canonical", hash)
+		}
+		return header, nil
+	}
 
-	return &RemoteStorage{
-		root:    latest.Root,
-		db:      db,
-		statedb: stateDb,
-		trie:    trie,
-		tracer:  tracer,
+	number, _ := block.Number()
+	switch number {
+	case rpc.LatestBlockNumber:
+		header := rawdb.ReadHeadHeader(db)
+		if header == nil {
+			return nil, errors.New("latest header not found")
+		}
+		return header, nil
+	case rpc.PendingBlockNumber, rpc.SafeBlockNumber, rpc.FinalizedBlockNumber:
+		return nil, fmt.Errorf("unsupported block tag %v", number)
+	}
+	hash := rawdb.ReadCanonicalHash(db, uint64(number))
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("canonical block %d not found", number)
+	}
+	header := rawdb.ReadHeader(db, hash, uint64(number))
+	if header == nil {
+		return nil, fmt.Errorf("header of block %d not found", number)
+	}
+	return header, nil
+}
+
+// openState (re)opens the account trie at the state root of the given block
+func (s *RemoteStorage) openState(header *types.Header) error {
+	// Check the availability of the state first, the trie only fails lazily
+	// on the first missing node
+	if _, err := s.triedb.Reader(header.Root); err != nil {
+		return fmt.Errorf("state of block %d is not available, it has been pruned or not synced yet (scheme %s): %w", header.Number.Uint64(), s.triedb.Scheme(), err)
+	}
+	trie, err := s.statedb.OpenTrie(header.Root)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.header = header
+	s.root = header.Root
+
+	// The handles and the cached values belong to the previous state
+	s.epoch++
+	reader := &trieReader{epoch: s.epoch, root: header.Root, trie: trie, storageTries: make(map[common.Address]state.Trie)}
+	s.readers = []*trieReader{reader}
+	s.idle = []*trieReader{reader}
+	s.accounts = make(map[common.Address]cachedAccount)
+	s.slots = lru.NewBasicLRU[slotKey, cachedSlot](slotCacheSize)
+
+	// The witness belongs to the previous state as well
+	s.witness = nil
+
+	// Use the snapshot only if it has a layer for the root
+	s.snap = nil
+	if s.snaps != nil {
+		s.snap = s.snaps.Snapshot(header.Root)
+	}
+	return nil
+}
+
+// acquireReader checks out a handle on the tries which isn't in use, opening
+// a new one on the current state if all of them are
+func (s *RemoteStorage) acquireReader() (*trieReader, error) {
+	s.lock.Lock()
+	if n := len(s.idle); n > 0 {
+		reader := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.lock.Unlock()
+		return reader, nil
+	}
+	epoch, root := s.epoch, s.root
+	s.lock.Unlock()
+
+	trie, err := s.statedb.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	reader := &trieReader{epoch: epoch, root: root, trie: trie, storageTries: make(map[common.Address]state.Trie)}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if reader.epoch == s.epoch {
+		s.readers = append(s.readers, reader)
+	}
+	return reader, nil
+}
+
+// releaseReader returns the handle, unless the state has been reopened since
+// it was checked out
+func (s *RemoteStorage) releaseReader(reader *trieReader) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if reader.epoch == s.epoch {
+		s.idle = append(s.idle, reader)
 	}
 }
 
+// Header returns the header of the block the state is opened at
+func (s *RemoteStorage) Header() *types.Header {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.header
+}
+
+// GetHash returns the canonical hash of the block with the given number
+func (s *RemoteStorage) GetHash(number uint64) common.Hash {
+	s.lock.Lock()
+	if s.witness != nil {
+		s.witness.AddBlockHash(number)
+	}
+	s.lock.Unlock()
+	return rawdb.ReadCanonicalHash(s.db, number)
+}
+
+// GetHeader returns the header of the block with the given hash and number
+func (s *RemoteStorage) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(s.db, hash, number)
+}
+
+// ChainConfig returns the chain config stored along with the genesis block
+func (s *RemoteStorage) ChainConfig() (*params.ChainConfig, error) {
+	genesis := rawdb.ReadCanonicalHash(s.db, 0)
+	config := rawdb.ReadChainConfig(s.db, genesis)
+	if config == nil {
+		return nil, fmt.Errorf("chain config not found for genesis %v", genesis)
+	}
+	return config, nil
+}
+
 func (s *RemoteStorage) IsWriteAllowed() bool {
 	return false
 }
 
-func (s *RemoteStorage) CreateAccount(common.Address) {}
+func (s *RemoteStorage) CreateAccount(common.Address) error {
+	return ErrWriteNotAllowed
+}
 
-func (s *RemoteStorage) SetBalance(common.Address, *uint256.Int) {}
+func (s *RemoteStorage) DeleteAccount(common.Address) error {
+	return ErrWriteNotAllowed
+}
 
-func (s *RemoteStorage) GetBalance(address common.Address) *uint256.Int {
-	account, err := s.trie.GetAccount(address)
-	if err != nil {
-		log.Error("Error getting account from db", "address", address, "err", err)
-		return nil
+func (s *RemoteStorage) Exist(address common.Address) (bool, error) {
+	account, _, err := s.getAccount(address)
+	return account != nil, err
+}
+
+func (s *RemoteStorage) Empty(address common.Address) (bool, error) {
+	account, _, err := s.getAccount(address)
+	if account == nil {
+		return true, err
+	}
+	return account.Nonce == 0 && account.Balance.IsZero() && common.BytesToHash(account.CodeHash) == types.EmptyCodeHash, nil
+}
+
+func (s *RemoteStorage) SetBalance(common.Address, *uint256.Int) error {
+	return ErrWriteNotAllowed
+}
+
+func (s *RemoteStorage) GetBalance(address common.Address) (*uint256.Int, error) {
+	account, source, err := s.getAccount(address)
+	if account == nil {
+		return nil, err
 	}
 	if s.tracer != nil {
-		s.tracer.CaptureStorageReads("entity", "balance", "address", address, "balance", account.Balance.Uint64())
+		s.tracer.CaptureStorageReads("entity", "balance", "address", address, "balance", account.Balance.Uint64(), "source", source)
 	}
-	return account.Balance
+	// The cached account is shared by all the readers
+	return new(uint256.Int).Set(account.Balance), nil
 }
 
-func (s *RemoteStorage) SetNonce(common.Address, uint64) {}
+func (s *RemoteStorage) SetNonce(common.Address, uint64) error {
+	return ErrWriteNotAllowed
+}
 
-func (s *RemoteStorage) GetNonce(address common.Address) *uint64 {
-	account, err := s.trie.GetAccount(address)
-	if err != nil {
-		log.Error("Error getting account from db", "address", address, "err", err)
-		return nil
+func (s *RemoteStorage) GetNonce(address common.Address) (*uint64, error) {
+	account, source, err := s.getAccount(address)
+	if account == nil {
+		return nil, err
 	}
 	if s.tracer != nil {
-		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", account.Nonce)
+		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", account.Nonce, "source", source)
 	}
-	return &account.Nonce
+	nonce := account.Nonce
+	return &nonce, nil
 }
 
-func (s *RemoteStorage) SetState(common.Address, common.Hash, common.Hash) {}
+func (s *RemoteStorage) SetCode(common.Address, []byte) error {
+	return ErrWriteNotAllowed
+}
 
-func (s *RemoteStorage) GetState(address common.Address, key common.Hash) common.Hash {
-	storageTrie := openStorageTrie(address, s.root, s.trie, s.statedb)
-	if storageTrie == nil {
-		return common.Hash{}
+func (s *RemoteStorage) GetCode(address common.Address) ([]byte, error) {
+	account, source, err := s.getAccount(address)
+	if account == nil {
+		return nil, err
+	}
+	codeHash := common.BytesToHash(account.CodeHash)
+	if codeHash == types.EmptyCodeHash {
+		return nil, nil
+	}
+	code := rawdb.ReadCode(s.db, codeHash)
+	if len(code) == 0 {
+		return nil, fmt.Errorf("code %v not found", codeHash)
 	}
+	s.lock.Lock()
+	if s.witness != nil {
+		s.witness.AddCode(code)
+	}
+	s.lock.Unlock()
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "code", "address", address, "size", len(code), "source", source)
+	}
+	return code, nil
+}
+
+// StateRoot returns the root the store was opened at. As the store is
+// read only, it never diverges from the on disk state.
+func (s *RemoteStorage) StateRoot() (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.root, nil
+}
+
+func (s *RemoteStorage) SetState(common.Address, common.Hash, common.Hash) error {
+	return ErrWriteNotAllowed
+}
 
+func (s *RemoteStorage) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	s.lock.Lock()
+	slot, ok := s.slots.Get(slotKey{address, key})
+	if ok {
+		s.stats.SlotHits++
+	} else {
+		s.stats.SlotMisses++
+	}
+	snap, epoch := s.snap, s.epoch
+	s.lock.Unlock()
+
+	// Concurrent misses of the same slot may read it more than once
+	if !ok {
+		var err error
+		slot, err = s.readSlot(snap, address, key)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		s.lock.Lock()
+		if slot.source == sourceSnapshot {
+			s.stats.SlotSnapshotReads++
+		} else {
+			s.stats.SlotTrieReads++
+		}
+		if epoch == s.epoch {
+			s.slots.Add(slotKey{address, key}, slot)
+		}
+		s.lock.Unlock()
+	}
+
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "state", "address", address, "key", key, "value", slot.value, "source", slot.source)
+	}
+	return slot.value, nil
+}
+
+// readSlot reads the slot from the snapshot if it covers it, or from the
+// storage trie of the account otherwise
+func (s *RemoteStorage) readSlot(snap snapshot.Snapshot, address common.Address, key common.Hash) (cachedSlot, error) {
+	if snap != nil {
+		enc, err := snap.Storage(crypto.Keccak256Hash(address.Bytes()), crypto.Keccak256Hash(key.Bytes()))
+		if err == nil {
+			slot := cachedSlot{source: sourceSnapshot}
+			if len(enc) > 0 {
+				_, content, _, err := rlp.Split(enc)
+				if err != nil {
+					return cachedSlot{}, fmt.Errorf("invalid slot %v in snapshot: %w", key, err)
+				}
+				slot.value.SetBytes(content)
+			}
+			return slot, nil
+		}
+		// The snapshot is still being generated, fall back to the trie
+	}
+
+	reader, err := s.acquireReader()
+	if err != nil {
+		return cachedSlot{}, err
+	}
+	defer s.releaseReader(reader)
+
+	slot := cachedSlot{source: sourceTrie}
+	storageTrie, err := s.storageTrie(reader, address)
+	if storageTrie == nil {
+		return slot, err
+	}
 	val, err := storageTrie.GetStorage(address, key.Bytes())
 	if err != nil {
-		log.Error("Error getting data from storage trie", "address", address, "key", key, "err", err)
-		return common.Hash{}
+		return cachedSlot{}, err
 	}
+	slot.value.SetBytes(val)
+	return slot, nil
+}
 
-	var value common.Hash
-	value.SetBytes(val)
-	if s.tracer != nil {
-		s.tracer.CaptureStorageReads("entity", "state", "address", address, "key", key, "value", value)
+// getAccount returns the account from the cache, reading it from the
+// snapshot or the account trie on the first access. Missing accounts are
+// cached as nil. It also returns the source the account was read from.
+func (s *RemoteStorage) getAccount(address common.Address) (*types.StateAccount, string, error) {
+	s.lock.Lock()
+	if cached, ok := s.accounts[address]; ok {
+		s.stats.AccountHits++
+		s.lock.Unlock()
+		return cached.account, cached.source, nil
 	}
-	return value
+	s.stats.AccountMisses++
+	snap, epoch := s.snap, s.epoch
+	s.lock.Unlock()
+
+	// Concurrent misses of the same account may read it more than once
+	cached, err := s.readAccount(snap, address)
+	if err != nil {
+		return nil, cached.source, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cached.source == sourceSnapshot {
+		s.stats.AccountSnapshotReads++
+	} else {
+		s.stats.AccountTrieReads++
+	}
+	if epoch == s.epoch {
+		s.accounts[address] = cached
+	}
+	return cached.account, cached.source, nil
 }
 
-func openStorageTrie(address common.Address, root common.Hash, globalTrie state.Trie, statedb state.Database) state.Trie {
-	account, err := globalTrie.GetAccount(address)
+// readAccount reads the account from the snapshot if it covers it, or from
+// the account trie otherwise
+func (s *RemoteStorage) readAccount(snap snapshot.Snapshot, address common.Address) (cachedAccount, error) {
+	if snap != nil {
+		data, err := snap.AccountRLP(crypto.Keccak256Hash(address.Bytes()))
+		if err == nil {
+			cached := cachedAccount{source: sourceSnapshot}
+			if len(data) > 0 {
+				if cached.account, err = types.FullAccount(data); err != nil {
+					return cached, fmt.Errorf("invalid account in snapshot: %w", err)
+				}
+			}
+			return cached, nil
+		}
+		// The snapshot is still being generated, fall back to the trie
+	}
+
+	cached := cachedAccount{source: sourceTrie}
+	reader, err := s.acquireReader()
 	if err != nil {
-		log.Error("Error getting account from db", "address", address, "err", err)
-		return nil
+		return cached, err
+	}
+	defer s.releaseReader(reader)
+
+	cached.account, err = reader.trie.GetAccount(address)
+	return cached, err
+}
+
+// storageTrie returns the storage trie of the account opened through the
+// handle, opening it on the first access. It returns nil if the account
+// doesn't exist.
+func (s *RemoteStorage) storageTrie(reader *trieReader, address common.Address) (state.Trie, error) {
+	storageTrie, ok := reader.storageTries[address]
+	s.lock.Lock()
+	if ok {
+		s.stats.TrieHits++
+	} else {
+		s.stats.TrieMisses++
+	}
+	s.lock.Unlock()
+	if ok {
+		return storageTrie, nil
+	}
+
+	account, _, err := s.getAccount(address)
+	if account == nil {
+		return nil, err
 	}
 
 	// Open the storage trie for the given contract address
-	trie, err := statedb.OpenStorageTrie(root, address, account.Root, globalTrie)
+	storageTrie, err = s.statedb.OpenStorageTrie(reader.root, address, account.Root, reader.trie)
 	if err != nil {
-		log.Error("Error opening storage trie", "address", address, "root", account.Root, "err", err)
-		return nil
+		return nil, fmt.Errorf("failed to open storage trie %v: %w", account.Root, err)
 	}
+	reader.storageTries[address] = storageTrie
+	return storageTrie, nil
+}
 
-	return trie
+// Stats returns the hit and miss counters of the caches
+func (s *RemoteStorage) Stats() CacheStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.stats
 }
 
 func (s *RemoteStorage) Close() {
+	stats := s.Stats()
+	log.Info("Closing remote storage", "account hits", stats.AccountHits, "account misses", stats.AccountMisses,
+		"trie hits", stats.TrieHits, "trie misses", stats.TrieMisses, "slot hits", stats.SlotHits, "slot misses", stats.SlotMisses,
+		"snapshot reads", stats.AccountSnapshotReads+stats.SlotSnapshotReads, "trie reads", stats.AccountTrieReads+stats.SlotTrieReads)
+	if s.snaps != nil {
+		s.snaps.Release()
+	}
+	s.triedb.Close()
 	s.db.Close()
 }
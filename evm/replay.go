@@ -0,0 +1,171 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ReplayResult contains the outcome of replaying a historical transaction
+// along with the receipt stored in the database
+type ReplayResult struct {
+	Result   *ExecutionResult
+	Local    *types.Receipt // receipt generated by the replay
+	Expected *types.Receipt // receipt stored in the database
+	Diffs    []string       // differences between the receipts, empty if they match
+
+	Witness *stateless.Witness // state read by the replay, nil unless recorded
+}
+
+// ReplayTransaction re-executes a historical transaction on top of the state
+// of its parent block. All the preceding transactions of the block are
+// applied first, in an untraced overlay, so that the remote database is never
+// written. Only the target transaction is traced.
+func ReplayTransaction(storage *RemoteStorage, hash common.Hash, tracer *Tracer) (*ReplayResult, error) {
+	return replayTransaction(storage, hash, false, tracer)
+}
+
+// ReplayTransactionWithWitness replays the transaction like ReplayTransaction
+// while recording every trie node, code and header read into a witness, from
+// which the transaction can be re-executed without the database
+func ReplayTransactionWithWitness(storage *RemoteStorage, hash common.Hash, tracer *Tracer) (*ReplayResult, error) {
+	return replayTransaction(storage, hash, true, tracer)
+}
+
+func replayTransaction(storage *RemoteStorage, hash common.Hash, recordWitness bool, tracer *Tracer) (*ReplayResult, error) {
+	tx, blockHash, number, index := rawdb.ReadTransaction(storage.db, hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %v not found (is the tx index available?)", hash)
+	}
+	block := rawdb.ReadBlock(storage.db, blockHash, number)
+	if block == nil {
+		return nil, fmt.Errorf("block %d (%v) not found", number, blockHash)
+	}
+	parent := rawdb.ReadHeader(storage.db, block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent block %d (%v) not found", number-1, block.ParentHash())
+	}
+	config, err := storage.ChainConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the state at the parent block
+	if err := storage.openState(parent); err != nil {
+		return nil, fmt.Errorf("state of parent block %d not available (root %v): %w", number-1, parent.Root, err)
+	}
+	log.Info("Opened state at parent block", "number", number-1, "root", parent.Root)
+	if recordWitness {
+		if err := storage.StartWitness(block); err != nil {
+			return nil, err
+		}
+	}
+
+	result, local, err := applyTransactions(config, block, storage, storage.GetHash, index, tracer)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := rawdb.ReadReceipts(storage.db, blockHash, number, block.Time(), config)
+	if int(index) >= len(receipts) {
+		return nil, fmt.Errorf("receipt of transaction %v not found", hash)
+	}
+	expected := receipts[index]
+
+	return &ReplayResult{
+		Result:   result,
+		Local:    local,
+		Expected: expected,
+		Diffs:    compareReceipts(local, expected),
+		Witness:  storage.Witness(),
+	}, nil
+}
+
+// applyTransactions applies the transactions of the block up to the one at
+// the given index on an overlay on top of the storage, which holds the state
+// of the parent block. Only the execution and the writes of the target
+// transaction are traced, on a second overlay, its result and receipt are
+// returned.
+func applyTransactions(config *params.ChainConfig, block *types.Block, storage Storage, getHash GetHashFunc, index uint64, tracer *Tracer) (*ExecutionResult, *types.Receipt, error) {
+	txs := block.Transactions()
+	if index >= uint64(len(txs)) {
+		return nil, nil, fmt.Errorf("transaction %d not found in block %d", index, block.NumberU64())
+	}
+	var (
+		overlay = NewOverlayStorage(storage, nil)
+		ctx     = NewBlockContext(block.Header(), getHash)
+		signer  = types.MakeSigner(config, block.Number(), block.Time())
+		usedGas uint64
+	)
+
+	// Apply the transactions preceding the target one
+	for i, prev := range txs[:index] {
+		msg, err := TransactionToMessage(prev, signer, ctx.BaseFee)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, prev.Hash().Hex(), err)
+		}
+		result, err := ApplyMessage(config, ctx, overlay, msg, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, prev.Hash().Hex(), err)
+		}
+		usedGas += result.UsedGas
+	}
+	log.Info("Applied preceding transactions", "count", index, "gas used", usedGas)
+
+	// Apply the target transaction
+	tx := txs[index]
+	msg, err := TransactionToMessage(tx, signer, ctx.BaseFee)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not apply tx %d [%v]: %w", index, tx.Hash().Hex(), err)
+	}
+	result, err := ApplyMessage(config, ctx, NewOverlayStorage(overlay, tracer), msg, tracer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not apply tx %d [%v]: %w", index, tx.Hash().Hex(), err)
+	}
+	usedGas += result.UsedGas
+	return result, newReceipt(tx, msg, result, usedGas), nil
+}
+
+// compareReceipts returns the differences in gas used, status and logs
+// between the two receipts
+func compareReceipts(local, expected *types.Receipt) []string {
+	diffs := make([]string, 0)
+	if local.GasUsed != expected.GasUsed {
+		diffs = append(diffs, fmt.Sprintf("gas used: local %d, expected %d", local.GasUsed, expected.GasUsed))
+	}
+	if local.CumulativeGasUsed != expected.CumulativeGasUsed {
+		diffs = append(diffs, fmt.Sprintf("cumulative gas used: local %d, expected %d", local.CumulativeGasUsed, expected.CumulativeGasUsed))
+	}
+	if local.Status != expected.Status {
+		diffs = append(diffs, fmt.Sprintf("status: local %d, expected %d", local.Status, expected.Status))
+	}
+	if len(local.Logs) != len(expected.Logs) {
+		diffs = append(diffs, fmt.Sprintf("log count: local %d, expected %d", len(local.Logs), len(expected.Logs)))
+		return diffs
+	}
+	for i := range local.Logs {
+		l, e := local.Logs[i], expected.Logs[i]
+		if l.Address != e.Address {
+			diffs = append(diffs, fmt.Sprintf("log %d address: local %v, expected %v", i, l.Address, e.Address))
+		}
+		if len(l.Topics) != len(e.Topics) {
+			diffs = append(diffs, fmt.Sprintf("log %d topic count: local %d, expected %d", i, len(l.Topics), len(e.Topics)))
+		} else {
+			for j := range l.Topics {
+				if l.Topics[j] != e.Topics[j] {
+					diffs = append(diffs, fmt.Sprintf("log %d topic %d: local %v, expected %v", i, j, l.Topics[j], e.Topics[j]))
+				}
+			}
+		}
+		if !bytes.Equal(l.Data, e.Data) {
+			diffs = append(diffs, fmt.Sprintf("log %d data: local %x, expected %x", i, l.Data, e.Data))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,360 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+)
+
+// rpcTimeout is the timeout of a single request to the endpoint
+const rpcTimeout = 30 * time.Second
+
+// RPCStorage represents a read only store backed by the JSON-RPC endpoint of
+// a node. All the queries are pinned to a single block. The responses are
+// cached in memory and persisted on disk when closed, so that later runs
+// against the same block work offline. It's safe for concurrent reads.
+type RPCStorage struct {
+	client *rpc.Client
+	header *types.Header // header of the block the queries are pinned to
+	number string        // hex encoded number of the block
+
+	lock      sync.Mutex                 // guards the cache, the requests are sent unlocked
+	cache     map[string]json.RawMessage // responses keyed by method and params
+	cachePath string                     // empty if the cache isn't persisted
+	dirty     bool                       // whether the cache has new responses
+
+	tracer *Tracer
+}
+
+// NewRPCStorage connects to the endpoint and pins the storage to the given
+// block. The cache is loaded from and saved to the given directory, unless
+// it's empty.
+func NewRPCStorage(url string, block rpc.BlockNumberOrHash, cacheDir string, tracer *Tracer) (*RPCStorage, error) {
+	client, err := rpc.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+
+	storage := &RPCStorage{
+		client: client,
+		cache:  make(map[string]json.RawMessage),
+		tracer: tracer,
+	}
+	if cacheDir != "" {
+		storage.cachePath = filepath.Join(cacheDir, "rpc-cache.json")
+		if err := storage.loadCache(); err != nil {
+			log.Warn("Unable to load rpc cache, starting with an empty one", "path", storage.cachePath, "err", err)
+		}
+	}
+
+	// Resolve the block the queries are pinned to
+	header, err := storage.readHeader(block)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to find block %s: %w", block.String(), err)
+	}
+	storage.header = header
+	storage.number = hexutil.EncodeBig(header.Number)
+
+	log.Info("Connected to rpc endpoint", "url", url, "number", header.Number.Uint64(), "hash", header.Hash(), "cached", len(storage.cache))
+
+	return storage, nil
+}
+
+// loadCache reads the persisted responses from disk
+func (s *RPCStorage) loadCache() error {
+	data, err := os.ReadFile(s.cachePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.cache)
+}
+
+// saveCache persists the responses on disk if there are new ones
+func (s *RPCStorage) saveCache() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cachePath == "" || !s.dirty {
+		return nil
+	}
+	data, err := json.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cachePath), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that the cache is never left corrupted
+	tmp := s.cachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.cachePath); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// call executes the request, serving it from the cache if possible. Empty
+// responses (null) are never cached.
+func (s *RPCStorage) call(result interface{}, method string, args ...interface{}) error {
+	key, err := json.Marshal(append([]interface{}{method}, args...))
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	raw, ok := s.cache[string(key)]
+	s.lock.Unlock()
+	if ok {
+		return json.Unmarshal(raw, result)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	if err := s.client.CallContext(ctx, &raw, method, args...); err != nil {
+		return err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return fmt.Errorf("empty response for %s", method)
+	}
+	s.lock.Lock()
+	s.cache[string(key)] = raw
+	s.dirty = true
+	s.lock.Unlock()
+	return json.Unmarshal(raw, result)
+}
+
+// latestKey is the cache key of the block the latest tag last resolved to
+const latestKey = `["eth_blockNumber"]`
+
+// readHeader returns the header of the given block. The latest block is
+// resolved through the endpoint, falling back to the block it last resolved
+// to when the endpoint is unreachable.
+func (s *RPCStorage) readHeader(block rpc.BlockNumberOrHash) (*types.Header, error) {
+	header := new(types.Header)
+	if hash, ok := block.Hash(); ok {
+		return header, s.call(header, "eth_getBlockByHash", hash, false)
+	}
+	number, _ := block.Number()
+	if number < 0 && number != rpc.LatestBlockNumber {
+		return nil, fmt.Errorf("unsupported block tag %v", number)
+	}
+	if number == rpc.LatestBlockNumber {
+		latest, err := s.latestNumber()
+		if err != nil {
+			return nil, err
+		}
+		number = rpc.BlockNumber(latest)
+	}
+	return header, s.call(header, "eth_getBlockByNumber", number, false)
+}
+
+// latestNumber resolves the latest block number through the endpoint and
+// caches it. The cached number is used if the endpoint is unreachable.
+func (s *RPCStorage) latestNumber() (hexutil.Uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	var (
+		latest hexutil.Uint64
+		raw    json.RawMessage
+	)
+	err := s.client.CallContext(ctx, &raw, "eth_blockNumber")
+	if err == nil {
+		if err := json.Unmarshal(raw, &latest); err != nil {
+			return 0, err
+		}
+		s.lock.Lock()
+		s.cache[latestKey] = raw
+		s.dirty = true
+		s.lock.Unlock()
+		return latest, nil
+	}
+
+	s.lock.Lock()
+	raw, ok := s.cache[latestKey]
+	s.lock.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("failed to resolve the latest block, an explicit --block is needed to run offline: %w", err)
+	}
+	if err := json.Unmarshal(raw, &latest); err != nil {
+		return 0, err
+	}
+	log.Warn("Endpoint unreachable, using the block latest last resolved to", "number", uint64(latest), "err", err)
+	return latest, nil
+}
+
+// Header returns the header of the block the queries are pinned to
+func (s *RPCStorage) Header() *types.Header {
+	return s.header
+}
+
+// GetHash returns the hash of the block with the given number
+func (s *RPCStorage) GetHash(number uint64) common.Hash {
+	header, err := s.readHeader(rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number)))
+	if err != nil {
+		log.Error("Error getting block from rpc", "number", number, "err", err)
+		return common.Hash{}
+	}
+	return header.Hash()
+}
+
+// ChainConfig returns the config of the known networks based on the chain
+// id of the endpoint. Other networks are assumed to have all the forks
+// activated.
+func (s *RPCStorage) ChainConfig() (*params.ChainConfig, error) {
+	var chainID hexutil.Big
+	if err := s.call(&chainID, "eth_chainId"); err != nil {
+		return nil, err
+	}
+	for _, config := range []*params.ChainConfig{params.MainnetChainConfig, params.SepoliaChainConfig, params.HoleskyChainConfig} {
+		if config.ChainID.Cmp(chainID.ToInt()) == 0 {
+			return config, nil
+		}
+	}
+	log.Warn("Unknown chain id, assuming all forks are active", "chainid", chainID.ToInt())
+	config := *params.MergedTestChainConfig
+	config.ChainID = new(big.Int).Set(chainID.ToInt())
+	return &config, nil
+}
+
+func (s *RPCStorage) IsWriteAllowed() bool {
+	return false
+}
+
+func (s *RPCStorage) CreateAccount(common.Address) error {
+	return ErrWriteNotAllowed
+}
+
+func (s *RPCStorage) DeleteAccount(common.Address) error {
+	return ErrWriteNotAllowed
+}
+
+// Exist reports whether the account is non empty, as missing and empty
+// accounts can't be told apart
+func (s *RPCStorage) Exist(address common.Address) (bool, error) {
+	balance, err := s.GetBalance(address)
+	return balance != nil, err
+}
+
+func (s *RPCStorage) Empty(address common.Address) (bool, error) {
+	exists, err := s.Exist(address)
+	return !exists, err
+}
+
+func (s *RPCStorage) SetBalance(common.Address, *uint256.Int) error {
+	return ErrWriteNotAllowed
+}
+
+// exists returns whether the account is non empty. An endpoint doesn't
+// distinguish between missing and empty accounts.
+func (s *RPCStorage) exists(address common.Address, balance *uint256.Int) (bool, error) {
+	if !balance.IsZero() {
+		return true, nil
+	}
+	var nonce hexutil.Uint64
+	if err := s.call(&nonce, "eth_getTransactionCount", address, s.number); err != nil {
+		return false, err
+	}
+	if nonce != 0 {
+		return true, nil
+	}
+	var code hexutil.Bytes
+	if err := s.call(&code, "eth_getCode", address, s.number); err != nil {
+		return false, err
+	}
+	return len(code) != 0, nil
+}
+
+func (s *RPCStorage) GetBalance(address common.Address) (*uint256.Int, error) {
+	var result hexutil.Big
+	if err := s.call(&result, "eth_getBalance", address, s.number); err != nil {
+		return nil, err
+	}
+	balance, overflow := uint256.FromBig(result.ToInt())
+	if overflow {
+		return nil, fmt.Errorf("invalid balance %v", result.ToInt())
+	}
+	exists, err := s.exists(address, balance)
+	if !exists {
+		return nil, err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "balance", "address", address, "balance", balance.Uint64())
+	}
+	return balance, nil
+}
+
+func (s *RPCStorage) SetNonce(common.Address, uint64) error {
+	return ErrWriteNotAllowed
+}
+
+func (s *RPCStorage) GetNonce(address common.Address) (*uint64, error) {
+	var result hexutil.Uint64
+	if err := s.call(&result, "eth_getTransactionCount", address, s.number); err != nil {
+		return nil, err
+	}
+	nonce := uint64(result)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", nonce)
+	}
+	return &nonce, nil
+}
+
+func (s *RPCStorage) SetCode(common.Address, []byte) error {
+	return ErrWriteNotAllowed
+}
+
+func (s *RPCStorage) GetCode(address common.Address) ([]byte, error) {
+	var code hexutil.Bytes
+	if err := s.call(&code, "eth_getCode", address, s.number); err != nil {
+		return nil, err
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "code", "address", address, "size", len(code))
+	}
+	return code, nil
+}
+
+func (s *RPCStorage) SetState(common.Address, common.Hash, common.Hash) error {
+	return ErrWriteNotAllowed
+}
+
+func (s *RPCStorage) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	var result hexutil.Bytes
+	if err := s.call(&result, "eth_getStorageAt", address, key, s.number); err != nil {
+		return common.Hash{}, err
+	}
+	value := common.BytesToHash(result)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageReads("entity", "state", "address", address, "key", key, "value", value)
+	}
+	return value, nil
+}
+
+// Close persists the cache and disconnects from the endpoint
+func (s *RPCStorage) Close() {
+	if err := s.saveCache(); err != nil {
+		log.Error("Unable to save rpc cache", "path", s.cachePath, "err", err)
+	}
+	s.client.Close()
+}
@@ -1,15 +1,25 @@
 package evm
 
 import (
+	"sync"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
 	"github.com/holiman/uint256"
 )
 
-// SimpleStorage is an in-memory store with a simple map underneath
+// SimpleStorage is an in-memory store with a simple map underneath. It's
+// safe for concurrent use, as long as it isn't traced.
 type SimpleStorage struct {
+	lock     sync.RWMutex
 	accounts map[common.Address]types.StateAccount
 	state    map[common.Address]map[common.Hash]common.Hash
+	code     map[common.Hash][]byte // code hash -> contract code
 
 	tracer *Tracer
 }
@@ -18,6 +28,7 @@ func NewSimpleStorage(tracer *Tracer) *SimpleStorage {
 	return &SimpleStorage{
 		accounts: make(map[common.Address]types.StateAccount),
 		state:    make(map[common.Address]map[common.Hash]common.Hash),
+		code:     make(map[common.Hash][]byte),
 		tracer:   tracer,
 	}
 }
@@ -26,35 +37,88 @@ func (s *SimpleStorage) IsWriteAllowed() bool {
 	return true
 }
 
-func (s *SimpleStorage) CreateAccount(address common.Address) {
+func (s *SimpleStorage) CreateAccount(address common.Address) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.getOrNewAccount(address)
+	return nil
+}
+
+// getOrNewAccount returns the account, creating it if it doesn't exist. The
+// lock must be held.
+func (s *SimpleStorage) getOrNewAccount(address common.Address) types.StateAccount {
+	if account, ok := s.accounts[address]; ok {
+		return account
+	}
+	account := types.StateAccount{
+		Nonce:    0,
+		Balance:  uint256.NewInt(0),
+		Root:     types.EmptyRootHash,
+		CodeHash: types.EmptyCodeHash.Bytes(),
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureAccountCreation("address", address, "nonce", account.Nonce, "balance", account.Balance.Uint64(), "root", account.Root, "codeHash", account.CodeHash)
+	}
+	s.accounts[address] = account
+	return account
+}
+
+// DeleteAccount removes the account along with its storage
+func (s *SimpleStorage) DeleteAccount(address common.Address) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	if _, ok := s.accounts[address]; !ok {
-		account := types.StateAccount{
-			Nonce:    0,
-			Balance:  uint256.NewInt(0),
-			Root:     types.EmptyRootHash,
-			CodeHash: []byte{},
-		}
-		if s.tracer != nil {
-			s.tracer.CaptureAccountCreation("address", address, "nonce", account.Nonce, "balance", account.Balance.Uint64(), "root", account.Root, "codeHash", account.CodeHash)
-		}
-		s.accounts[address] = account
+		return nil
+	}
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "account", "address", address, "deleted", true)
 	}
+	delete(s.accounts, address)
+	delete(s.state, address)
+	return nil
 }
 
-func (s *SimpleStorage) SetBalance(address common.Address, balance *uint256.Int) {
-	if account, ok := s.accounts[address]; ok {
-		if s.tracer != nil {
-			s.tracer.CaptureStorageWrites("entity", "balance", "address", address, "old", account.Balance.Uint64(), "new", balance.Uint64())
-		}
-		account.Balance = balance
-		s.accounts[address] = account
+func (s *SimpleStorage) Exist(address common.Address) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.accounts[address]
+	return ok, nil
+}
+
+func (s *SimpleStorage) Empty(address common.Address) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	account, ok := s.accounts[address]
+	if !ok {
+		return true, nil
 	}
+	return account.Nonce == 0 && account.Balance.IsZero() && common.BytesToHash(account.CodeHash) == types.EmptyCodeHash, nil
 }
 
-func (s *SimpleStorage) GetBalance(address common.Address) *uint256.Int {
+func (s *SimpleStorage) SetBalance(address common.Address, balance *uint256.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	account := s.getOrNewAccount(address)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "balance", "address", address, "old", account.Balance.Uint64(), "new", balance.Uint64())
+	}
+	account.Balance = balance
+	s.accounts[address] = account
+	return nil
+}
+
+func (s *SimpleStorage) GetBalance(address common.Address) (*uint256.Int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	var balance *uint256.Int
 	if account, ok := s.accounts[address]; ok {
-		balance = account.Balance
+		balance = new(uint256.Int).Set(account.Balance)
 	}
 
 	if s.tracer != nil {
@@ -65,33 +129,78 @@ func (s *SimpleStorage) GetBalance(address common.Address) *uint256.Int {
 		s.tracer.CaptureStorageReads("entity", "balance", "address", address, "balance", value)
 	}
 
-	return balance
+	return balance, nil
+}
+
+func (s *SimpleStorage) SetNonce(address common.Address, nonce uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	account := s.getOrNewAccount(address)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "nonce", "address", address, "old", account.Nonce, "new", nonce)
+	}
+	account.Nonce = nonce
+	s.accounts[address] = account
+	return nil
 }
 
-func (s *SimpleStorage) SetNonce(address common.Address, nonce uint64) {
+func (s *SimpleStorage) GetNonce(address common.Address) (*uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var nonce *uint64
 	if account, ok := s.accounts[address]; ok {
-		if s.tracer != nil {
-			s.tracer.CaptureStorageWrites("entity", "nonce", "address", address, "old", account.Nonce, "new", nonce)
+		value := account.Nonce
+		nonce = &value
+	}
+
+	if s.tracer != nil {
+		var value uint64 = 0
+		if nonce != nil {
+			value = *nonce
 		}
-		account.Nonce = nonce
-		s.accounts[address] = account
+		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", value)
 	}
+
+	return nonce, nil
 }
 
-func (s *SimpleStorage) GetNonce(address common.Address) *uint64 {
-	var nonce *uint64
+func (s *SimpleStorage) SetCode(address common.Address, code []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	account := s.getOrNewAccount(address)
+	codeHash := crypto.Keccak256Hash(code)
+	if s.tracer != nil {
+		s.tracer.CaptureStorageWrites("entity", "code", "address", address, "old", common.BytesToHash(account.CodeHash), "new", codeHash)
+	}
+	s.code[codeHash] = code
+	account.CodeHash = codeHash.Bytes()
+	s.accounts[address] = account
+	return nil
+}
+
+func (s *SimpleStorage) GetCode(address common.Address) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var code []byte
 	if account, ok := s.accounts[address]; ok {
-		nonce = &account.Nonce
+		code = s.code[common.BytesToHash(account.CodeHash)]
 	}
 
 	if s.tracer != nil {
-		s.tracer.CaptureStorageReads("entity", "nonce", "address", address, "nonce", *nonce)
+		s.tracer.CaptureStorageReads("entity", "code", "address", address, "size", len(code))
 	}
 
-	return nonce
+	return code, nil
 }
 
-func (s *SimpleStorage) SetState(address common.Address, key common.Hash, value common.Hash) {
+func (s *SimpleStorage) SetState(address common.Address, key common.Hash, value common.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	if _, ok := s.state[address]; !ok {
 		s.state[address] = make(map[common.Hash]common.Hash)
 	}
@@ -100,9 +209,13 @@ func (s *SimpleStorage) SetState(address common.Address, key common.Hash, value
 		s.tracer.CaptureStorageWrites("entity", "state", "address", address, "key", key, "old", s.state[address][key], "new", value)
 	}
 	s.state[address][key] = value
+	return nil
 }
 
-func (s *SimpleStorage) GetState(address common.Address, key common.Hash) common.Hash {
+func (s *SimpleStorage) GetState(address common.Address, key common.Hash) (common.Hash, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	val := common.Hash{}
 	if state, ok := s.state[address]; ok {
 		if value, ok := state[key]; ok {
@@ -114,7 +227,55 @@ func (s *SimpleStorage) GetState(address common.Address, key common.Hash) common
 		s.tracer.CaptureStorageReads("entity", "state", "address", address, "key", key, "value", val)
 	}
 
-	return val
+	return val, nil
+}
+
+// StateRoot builds the storage tries and the account trie in memory and
+// returns the root of the state. The storage roots of the accounts are
+// updated along the way. Zero slots are left out as in geth.
+func (s *SimpleStorage) StateRoot() (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	db := triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+	defer db.Close()
+
+	accountTrie := trie.NewEmpty(db)
+	for address, account := range s.accounts {
+		root, err := s.storageRoot(db, address)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		account.Root = root
+		s.accounts[address] = account
+
+		data, err := rlp.EncodeToBytes(&account)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if err := accountTrie.Update(crypto.Keccak256(address.Bytes()), data); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return accountTrie.Hash(), nil
+}
+
+// storageRoot returns the root of the storage trie of the account
+func (s *SimpleStorage) storageRoot(db *triedb.Database, address common.Address) (common.Hash, error) {
+	storageTrie := trie.NewEmpty(db)
+	for key, value := range s.state[address] {
+		if value == (common.Hash{}) {
+			continue
+		}
+		data, err := rlp.EncodeToBytes(common.TrimLeftZeroes(value.Bytes()))
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if err := storageTrie.Update(crypto.Keccak256(key.Bytes()), data); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return storageTrie.Hash(), nil
 }
 
 func (s *SimpleStorage) Close() {}
@@ -0,0 +1,324 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/uint256"
+)
+
+// AccountState contains the fields of an account, nil fields are left out
+type AccountState struct {
+	Balance *uint256.Int
+	Nonce   *uint64
+	Code    []byte
+	Storage map[common.Hash]common.Hash
+}
+
+// StateDiff contains the modified accounts before and after an execution,
+// in the same way as geth's prestateTracer in diffMode. The pre state holds
+// the balance, nonce and code of every modified account along with the
+// previous value of the modified slots, while the post state only holds the
+// modified fields. Accounts which didn't exist before are only part of the
+// post state and deleted accounts only of the pre state.
+type StateDiff struct {
+	Pre  map[common.Address]*AccountState
+	Post map[common.Address]*AccountState
+}
+
+// prestateAccount is an account in the json format of geth's prestateTracer,
+// zero fields are left out
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// prestateDiff is the json format of geth's prestateTracer in diffMode
+type prestateDiff struct {
+	Post map[common.Address]*prestateAccount `json:"post"`
+	Pre  map[common.Address]*prestateAccount `json:"pre"`
+}
+
+// toPrestate converts the accounts to the format of the prestateTracer. As
+// in geth, zero slots are left out: a cleared slot only shows up in the pre
+// state and a written slot which was zero only in the post state.
+func toPrestate(accounts map[common.Address]*AccountState) map[common.Address]*prestateAccount {
+	converted := make(map[common.Address]*prestateAccount, len(accounts))
+	for address, account := range accounts {
+		prestate := &prestateAccount{Code: account.Code}
+		if account.Balance != nil {
+			prestate.Balance = (*hexutil.Big)(account.Balance.ToBig())
+		}
+		if account.Nonce != nil {
+			prestate.Nonce = *account.Nonce
+		}
+		for key, value := range account.Storage {
+			if value == (common.Hash{}) {
+				continue
+			}
+			if prestate.Storage == nil {
+				prestate.Storage = make(map[common.Hash]common.Hash)
+			}
+			prestate.Storage[key] = value
+		}
+		converted[address] = prestate
+	}
+	return converted
+}
+
+// fromPrestate converts the accounts from the format of the prestateTracer
+func fromPrestate(accounts map[common.Address]*prestateAccount) (map[common.Address]*AccountState, error) {
+	converted := make(map[common.Address]*AccountState, len(accounts))
+	for address, prestate := range accounts {
+		account := &AccountState{Code: prestate.Code, Storage: prestate.Storage}
+		if prestate.Balance != nil {
+			balance, overflow := uint256.FromBig(prestate.Balance.ToInt())
+			if overflow {
+				return nil, fmt.Errorf("balance of %v overflows", address)
+			}
+			account.Balance = balance
+		}
+		if prestate.Nonce != 0 {
+			nonce := prestate.Nonce
+			account.Nonce = &nonce
+		}
+		converted[address] = account
+	}
+	return converted, nil
+}
+
+// MarshalJSON encodes the diff in the same format as geth's prestateTracer
+// in diffMode
+func (diff *StateDiff) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&prestateDiff{
+		Post: toPrestate(diff.Post),
+		Pre:  toPrestate(diff.Pre),
+	})
+}
+
+// UnmarshalJSON decodes a diff in the format of geth's prestateTracer in
+// diffMode, e.g. the expected changes of an execution
+func (diff *StateDiff) UnmarshalJSON(input []byte) error {
+	var dec prestateDiff
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	post, err := fromPrestate(dec.Post)
+	if err != nil {
+		return err
+	}
+	pre, err := fromPrestate(dec.Pre)
+	if err != nil {
+		return err
+	}
+	diff.Post, diff.Pre = post, pre
+	return nil
+}
+
+// accountSnapshot is the state of an account at some point along with the
+// values of some of its slots
+type accountSnapshot struct {
+	exists  bool
+	balance *uint256.Int
+	nonce   uint64
+	code    []byte
+	storage map[common.Hash]common.Hash
+}
+
+// snapshotAccount reads the account and the given slots from the storage
+func snapshotAccount(storage Storage, address common.Address, keys []common.Hash) (*accountSnapshot, error) {
+	account := &accountSnapshot{storage: make(map[common.Hash]common.Hash)}
+	balance, err := storage.GetBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	if balance != nil {
+		account.exists = true
+		account.balance = new(uint256.Int).Set(balance)
+		if account.code, err = storage.GetCode(address); err != nil {
+			return nil, err
+		}
+		nonce, err := storage.GetNonce(address)
+		if err != nil {
+			return nil, err
+		}
+		if nonce != nil {
+			account.nonce = *nonce
+		}
+	}
+	for _, key := range keys {
+		if account.storage[key], err = storage.GetState(address, key); err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+// diffAccount returns the account before and after, nil if the account
+// hasn't changed. The pre state is nil for new accounts and the post state
+// is nil for deleted ones.
+func diffAccount(prev, current *accountSnapshot) (*AccountState, *AccountState) {
+	prevNonce := prev.nonce
+	pre := &AccountState{Balance: prev.balance, Nonce: &prevNonce, Code: prev.code}
+	if !current.exists {
+		if !prev.exists {
+			return nil, nil
+		}
+		for key, value := range prev.storage {
+			if pre.Storage == nil {
+				pre.Storage = make(map[common.Hash]common.Hash)
+			}
+			pre.Storage[key] = value
+		}
+		return pre, nil
+	}
+	post := new(AccountState)
+	changed := false
+	if !prev.exists || !prev.balance.Eq(current.balance) {
+		post.Balance = current.balance
+		changed = true
+	}
+	if !prev.exists || prev.nonce != current.nonce {
+		nonce := current.nonce
+		post.Nonce = &nonce
+		changed = true
+	}
+	if !bytes.Equal(prev.code, current.code) {
+		post.Code = current.code
+		changed = true
+	}
+	for key, value := range current.storage {
+		if prev.storage[key] == value {
+			continue
+		}
+		if pre.Storage == nil {
+			pre.Storage = make(map[common.Hash]common.Hash)
+			post.Storage = make(map[common.Hash]common.Hash)
+		}
+		pre.Storage[key], post.Storage[key] = prev.storage[key], value
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	if !prev.exists {
+		return nil, post
+	}
+	return pre, post
+}
+
+// add adds the account to the diff if it has changed
+func (diff *StateDiff) add(address common.Address, prev, current *accountSnapshot) {
+	pre, post := diffAccount(prev, current)
+	if pre != nil {
+		diff.Pre[address] = pre
+	}
+	if post != nil {
+		diff.Post[address] = post
+	}
+}
+
+func newStateDiff() *StateDiff {
+	return &StateDiff{
+		Pre:  make(map[common.Address]*AccountState),
+		Post: make(map[common.Address]*AccountState),
+	}
+}
+
+// stateRecorder wraps a storage and records the previous value of every
+// account and storage slot which is written through it
+type stateRecorder struct {
+	Storage
+
+	accounts map[common.Address]*accountSnapshot
+}
+
+func newStateRecorder(storage Storage) *stateRecorder {
+	return &stateRecorder{
+		Storage:  storage,
+		accounts: make(map[common.Address]*accountSnapshot),
+	}
+}
+
+// record stores the current state of the account if it's the first write
+func (r *stateRecorder) record(address common.Address) (*accountSnapshot, error) {
+	if account, ok := r.accounts[address]; ok {
+		return account, nil
+	}
+	account, err := snapshotAccount(r.Storage, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.accounts[address] = account
+	return account, nil
+}
+
+func (r *stateRecorder) CreateAccount(address common.Address) error {
+	if _, err := r.record(address); err != nil {
+		return err
+	}
+	return r.Storage.CreateAccount(address)
+}
+
+func (r *stateRecorder) DeleteAccount(address common.Address) error {
+	if _, err := r.record(address); err != nil {
+		return err
+	}
+	return r.Storage.DeleteAccount(address)
+}
+
+func (r *stateRecorder) SetBalance(address common.Address, balance *uint256.Int) error {
+	if _, err := r.record(address); err != nil {
+		return err
+	}
+	return r.Storage.SetBalance(address, balance)
+}
+
+func (r *stateRecorder) SetNonce(address common.Address, nonce uint64) error {
+	if _, err := r.record(address); err != nil {
+		return err
+	}
+	return r.Storage.SetNonce(address, nonce)
+}
+
+func (r *stateRecorder) SetCode(address common.Address, code []byte) error {
+	if _, err := r.record(address); err != nil {
+		return err
+	}
+	return r.Storage.SetCode(address, code)
+}
+
+func (r *stateRecorder) SetState(address common.Address, key common.Hash, value common.Hash) error {
+	account, err := r.record(address)
+	if err != nil {
+		return err
+	}
+	if _, ok := account.storage[key]; !ok {
+		if account.storage[key], err = r.Storage.GetState(address, key); err != nil {
+			return err
+		}
+	}
+	return r.Storage.SetState(address, key, value)
+}
+
+// diff compares the recorded accounts against their current state
+func (r *stateRecorder) diff() (*StateDiff, error) {
+	diff := newStateDiff()
+	for address, prev := range r.accounts {
+		keys := make([]common.Hash, 0, len(prev.storage))
+		for key := range prev.storage {
+			keys = append(keys, key)
+		}
+		current, err := snapshotAccount(r.Storage, address, keys)
+		if err != nil {
+			return nil, err
+		}
+		diff.add(address, prev, current)
+	}
+	return diff, nil
+}
@@ -0,0 +1,263 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/uint256"
+)
+
+// Formats in which the state of the simple storage can be exported
+const (
+	AllocFormat = "alloc" // genesis alloc
+	DumpFormat  = "dump"  // output of geth dump
+)
+
+// LoadAlloc creates the accounts of the genesis alloc along with their
+// balance, nonce, code and storage. Existing accounts are overwritten.
+func (s *SimpleStorage) LoadAlloc(alloc types.GenesisAlloc) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for address, account := range alloc {
+		balance := new(uint256.Int)
+		if account.Balance != nil {
+			var overflow bool
+			if balance, overflow = uint256.FromBig(account.Balance); overflow {
+				return fmt.Errorf("balance of %v overflows", address)
+			}
+		}
+		codeHash := types.EmptyCodeHash
+		if len(account.Code) > 0 {
+			codeHash = crypto.Keccak256Hash(account.Code)
+			s.code[codeHash] = account.Code
+		}
+		s.accounts[address] = types.StateAccount{
+			Nonce:    account.Nonce,
+			Balance:  balance,
+			Root:     types.EmptyRootHash,
+			CodeHash: codeHash.Bytes(),
+		}
+		s.state[address] = make(map[common.Hash]common.Hash, len(account.Storage))
+		for key, value := range account.Storage {
+			s.state[address][key] = value
+		}
+	}
+	return nil
+}
+
+// Alloc returns the accounts of the storage as a genesis alloc. Zero slots
+// are left out.
+func (s *SimpleStorage) Alloc() types.GenesisAlloc {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	alloc := make(types.GenesisAlloc, len(s.accounts))
+	for address, account := range s.accounts {
+		genesisAccount := types.Account{
+			Balance: account.Balance.ToBig(),
+			Nonce:   account.Nonce,
+			Code:    s.code[common.BytesToHash(account.CodeHash)],
+		}
+		for key, value := range s.state[address] {
+			if value == (common.Hash{}) {
+				continue
+			}
+			if genesisAccount.Storage == nil {
+				genesisAccount.Storage = make(map[common.Hash]common.Hash)
+			}
+			genesisAccount.Storage[key] = value
+		}
+		alloc[address] = genesisAccount
+	}
+	return alloc
+}
+
+// Dump returns the state of the storage in the same format as geth dump,
+// including the storage roots and the state root
+func (s *SimpleStorage) Dump() (*state.Dump, error) {
+	root, err := s.StateRoot()
+	if err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	dump := &state.Dump{
+		Root:     fmt.Sprintf("%x", root),
+		Accounts: make(map[string]state.DumpAccount, len(s.accounts)),
+	}
+	for address, account := range s.accounts {
+		dumpAccount := state.DumpAccount{
+			Balance:     account.Balance.ToBig().String(),
+			Nonce:       account.Nonce,
+			Root:        account.Root.Bytes(),
+			CodeHash:    account.CodeHash,
+			Code:        s.code[common.BytesToHash(account.CodeHash)],
+			AddressHash: crypto.Keccak256(address.Bytes()),
+		}
+		for key, value := range s.state[address] {
+			if value == (common.Hash{}) {
+				continue
+			}
+			if dumpAccount.Storage == nil {
+				dumpAccount.Storage = make(map[common.Hash]string)
+			}
+			dumpAccount.Storage[key] = common.Bytes2Hex(common.TrimLeftZeroes(value.Bytes()))
+		}
+		dump.Accounts[address.String()] = dumpAccount
+	}
+	return dump, nil
+}
+
+// ImportState loads the accounts from a genesis file, a genesis alloc or the
+// output of geth dump (either full or iterative) into the storage
+func (s *SimpleStorage) ImportState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	alloc, err := parseState(data)
+	if err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	log.Info("Importing state", "path", path, "accounts", len(alloc))
+	return s.LoadAlloc(alloc)
+}
+
+// ExportState writes the accounts of the storage to the given path either as
+// a genesis alloc or in the format of geth dump
+func (s *SimpleStorage) ExportState(path string, format string) error {
+	var (
+		out      interface{}
+		accounts int
+	)
+	switch format {
+	case AllocFormat:
+		alloc := s.Alloc()
+		out, accounts = alloc, len(alloc)
+	case DumpFormat:
+		dump, err := s.Dump()
+		if err != nil {
+			return err
+		}
+		out, accounts = dump, len(dump.Accounts)
+	default:
+		return fmt.Errorf("invalid state format %s", format)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Info("Exporting state", "path", path, "format", format, "accounts", accounts)
+	return os.WriteFile(path, data, 0644)
+}
+
+// parseState detects the format of the state file and converts it to a
+// genesis alloc
+func parseState(data []byte) (types.GenesisAlloc, error) {
+	var values []json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("empty state")
+	}
+
+	// An iterative dump has the root on the first line and an account per line
+	if len(values) > 1 {
+		alloc := make(types.GenesisAlloc)
+		for _, value := range values[1:] {
+			var account state.DumpAccount
+			if err := json.Unmarshal(value, &account); err != nil {
+				return nil, err
+			}
+			if account.Address == nil {
+				log.Warn("Skipping account without address preimage", "key", account.AddressHash)
+				continue
+			}
+			genesisAccount, err := dumpToGenesis(account)
+			if err != nil {
+				return nil, err
+			}
+			alloc[*account.Address] = genesisAccount
+		}
+		return alloc, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(values[0], &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["alloc"]; ok {
+		var genesis struct {
+			Alloc types.GenesisAlloc `json:"alloc"`
+		}
+		if err := json.Unmarshal(values[0], &genesis); err != nil {
+			return nil, err
+		}
+		return genesis.Alloc, nil
+	}
+	if _, ok := fields["accounts"]; ok {
+		var dump state.Dump
+		if err := json.Unmarshal(values[0], &dump); err != nil {
+			return nil, err
+		}
+		alloc := make(types.GenesisAlloc, len(dump.Accounts))
+		for key, account := range dump.Accounts {
+			if strings.HasPrefix(key, "pre(") {
+				log.Warn("Skipping account without address preimage", "key", account.AddressHash)
+				continue
+			}
+			genesisAccount, err := dumpToGenesis(account)
+			if err != nil {
+				return nil, err
+			}
+			alloc[common.HexToAddress(key)] = genesisAccount
+		}
+		return alloc, nil
+	}
+	var alloc types.GenesisAlloc
+	if err := json.Unmarshal(values[0], &alloc); err != nil {
+		return nil, err
+	}
+	return alloc, nil
+}
+
+// dumpToGenesis converts an account of geth dump to a genesis account
+func dumpToGenesis(account state.DumpAccount) (types.Account, error) {
+	balance, ok := new(big.Int).SetString(account.Balance, 10)
+	if !ok {
+		return types.Account{}, fmt.Errorf("invalid balance %q", account.Balance)
+	}
+	genesisAccount := types.Account{
+		Balance: balance,
+		Nonce:   account.Nonce,
+		Code:    account.Code,
+	}
+	if len(account.Storage) > 0 {
+		genesisAccount.Storage = make(map[common.Hash]common.Hash, len(account.Storage))
+		for key, value := range account.Storage {
+			genesisAccount.Storage[key] = common.HexToHash(value)
+		}
+	}
+	return genesisAccount, nil
+}
@@ -0,0 +1,359 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// Message contains the fields of a transaction which are needed to apply
+// it on top of the state
+type Message struct {
+	From       common.Address
+	To         *common.Address // nil for contract creation
+	Nonce      uint64
+	Value      *uint256.Int
+	GasLimit   uint64
+	GasPrice   *uint256.Int // effective gas price paid per unit of gas
+	GasFeeCap  *uint256.Int
+	GasTipCap  *uint256.Int
+	Data       []byte
+	AccessList types.AccessList
+
+	// SkipAccountChecks disables the nonce check and allows zero gas prices
+	// below the base fee. It's used for simulations like eth_call.
+	SkipAccountChecks bool
+}
+
+// TransactionToMessage converts a signed transaction into a message by
+// recovering the sender and computing the effective gas price
+func TransactionToMessage(tx *types.Transaction, signer types.Signer, baseFee *uint256.Int) (*Message, error) {
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		From:       from,
+		To:         tx.To(),
+		Nonce:      tx.Nonce(),
+		Value:      uint256.MustFromBig(tx.Value()),
+		GasLimit:   tx.Gas(),
+		GasPrice:   uint256.MustFromBig(tx.GasPrice()),
+		GasFeeCap:  uint256.MustFromBig(tx.GasFeeCap()),
+		GasTipCap:  uint256.MustFromBig(tx.GasTipCap()),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	}
+	// The effective gas price is min(tip + base fee, fee cap)
+	if baseFee != nil {
+		msg.GasPrice = new(uint256.Int).Add(msg.GasTipCap, baseFee)
+		if msg.GasPrice.Gt(msg.GasFeeCap) {
+			msg.GasPrice.Set(msg.GasFeeCap)
+		}
+	}
+	return msg, nil
+}
+
+// ApplyMessage applies the message on top of the storage. It checks the
+// nonce and balance of the sender, buys the gas, executes the message and
+// finally refunds the leftover gas and pays the fees to the coinbase. From
+// EIP-158 on, the accounts touched by the message which are left empty are
+// deleted at the end, as defined by EIP-161.
+//
+// The returned error is set if the message is invalid (e.g. nonce too low),
+// in which case the storage is left untouched, or if the storage fails to
+// read or write the state (*StorageError), in which case the storage may be
+// partially modified. Execution failures are reported via the result.
+func ApplyMessage(config *params.ChainConfig, block BlockContext, storage Storage, msg *Message, tracer *Tracer) (*ExecutionResult, error) {
+	// The merge flag only gates the post-merge forks which are activated
+	// by timestamp, so it's safe to assume it here.
+	rules := config.Rules(new(big.Int).SetUint64(block.Number), true, block.Time)
+	contractCreation := msg.To == nil
+
+	// Check the nonce of the sender
+	var stNonce uint64
+	nonce, err := storage.GetNonce(msg.From)
+	if err != nil {
+		return nil, &StorageError{Op: "nonce check", Address: msg.From, Err: err}
+	}
+	if nonce != nil {
+		stNonce = *nonce
+	}
+	if msg.SkipAccountChecks {
+		// Use the nonce from the state instead, e.g. for deriving the contract address
+		cpy := *msg
+		cpy.Nonce = stNonce
+		msg = &cpy
+	} else if msg.Nonce < stNonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooLow, msg.From.Hex(), msg.Nonce, stNonce)
+	} else if msg.Nonce > stNonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooHigh, msg.From.Hex(), msg.Nonce, stNonce)
+	}
+
+	// Check the fee cap against the base fee, zero fees are allowed for simulations
+	skipBaseFee := msg.SkipAccountChecks && msg.GasFeeCap.IsZero() && msg.GasTipCap.IsZero()
+	if block.BaseFee != nil && !skipBaseFee && msg.GasFeeCap.Lt(block.BaseFee) {
+		return nil, fmt.Errorf("%w: address %v, maxFeePerGas: %v, baseFee: %v", ErrFeeCapTooLow, msg.From.Hex(), msg.GasFeeCap, block.BaseFee)
+	}
+
+	// Check if the sender can afford the gas and the value
+	gasLimit := new(uint256.Int).SetUint64(msg.GasLimit)
+	gasCost := new(uint256.Int).Mul(gasLimit, msg.GasPrice)
+	required := new(uint256.Int).Mul(gasLimit, msg.GasFeeCap)
+	required.Add(required, msg.Value)
+	balance, err := getBalance(storage, msg.From)
+	if err != nil {
+		return nil, &StorageError{Op: "balance check", Address: msg.From, Err: err}
+	}
+	if balance.Lt(required) {
+		return nil, fmt.Errorf("%w: address %v have %v want %v", ErrInsufficientFunds, msg.From.Hex(), balance, required)
+	}
+
+	// Check the intrinsic gas
+	intrinsic, err := IntrinsicGas(config, block, msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.GasLimit < intrinsic {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrIntrinsicGas, msg.GasLimit, intrinsic)
+	}
+
+	// The message is valid, buy the gas and increment the nonce
+	if err := storage.SetBalance(msg.From, new(uint256.Int).Sub(balance, gasCost)); err != nil {
+		return nil, &StorageError{Op: "buy gas", Address: msg.From, Err: err}
+	}
+	if err := storage.SetNonce(msg.From, msg.Nonce+1); err != nil {
+		return nil, &StorageError{Op: "nonce increment", Address: msg.From, Err: err}
+	}
+
+	var (
+		gas    = msg.GasLimit - intrinsic
+		result *ExecutionResult
+		vm     *EVM
+	)
+	if contractCreation {
+		vm, result = create(rules, block, storage, msg, gas, tracer)
+	} else {
+		vm, result = call(rules, block, storage, msg, gas, tracer)
+	}
+	// A storage failure makes the outcome of the execution unknown
+	var storageErr *StorageError
+	if errors.As(result.Err, &storageErr) {
+		return nil, result.Err
+	}
+
+	// Apply the refund counter, capped by the refund quotient
+	gasLeft := vm.executionOpts.gas
+	gasUsed := msg.GasLimit - gasLeft
+	refundQuotient := params.RefundQuotient
+	if rules.IsLondon {
+		refundQuotient = params.RefundQuotientEIP3529
+	}
+	refund := min(vm.executionOpts.refund, gasUsed/refundQuotient)
+	if result.Failed() {
+		refund = 0
+	}
+	gasLeft += refund
+	gasUsed -= refund
+
+	// Return the leftover gas to the sender
+	remaining := new(uint256.Int).Mul(new(uint256.Int).SetUint64(gasLeft), msg.GasPrice)
+	if err := addBalance(storage, msg.From, remaining); err != nil {
+		return nil, &StorageError{Op: "gas refund", Address: msg.From, Err: err}
+	}
+
+	// Pay the tip to the coinbase, the base fee is burnt
+	tip := new(uint256.Int).Set(msg.GasPrice)
+	if block.BaseFee != nil {
+		if tip.Lt(block.BaseFee) {
+			tip.Clear()
+		} else {
+			tip.Sub(tip, block.BaseFee)
+		}
+	}
+	if err := addBalance(storage, block.Coinbase, new(uint256.Int).Mul(new(uint256.Int).SetUint64(gasUsed), tip)); err != nil {
+		return nil, &StorageError{Op: "tip payment", Address: block.Coinbase, Err: err}
+	}
+
+	// The sender and the coinbase are touched even if nothing is paid
+	if rules.IsEIP158 {
+		touched := vm.journal.dirtied()
+		touched[msg.From] = struct{}{}
+		touched[block.Coinbase] = struct{}{}
+		if err := deleteEmptyAccounts(storage, touched); err != nil {
+			return nil, err
+		}
+	}
+
+	result.UsedGas = gasUsed
+	result.RefundedGas = refund
+	return result, nil
+}
+
+// IntrinsicGas returns the gas charged for the message before any execution
+func IntrinsicGas(config *params.ChainConfig, block BlockContext, msg *Message) (uint64, error) {
+	rules := config.Rules(new(big.Int).SetUint64(block.Number), true, block.Time)
+	return core.IntrinsicGas(msg.Data, msg.AccessList, msg.To == nil, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
+}
+
+// deleteEmptyAccounts deletes the touched accounts which exist but are empty
+func deleteEmptyAccounts(storage Storage, touched map[common.Address]struct{}) error {
+	for address := range touched {
+		exists, err := storage.Exist(address)
+		if err != nil {
+			return &StorageError{Op: "empty account deletion", Address: address, Err: err}
+		}
+		if !exists {
+			continue
+		}
+		empty, err := storage.Empty(address)
+		if err != nil {
+			return &StorageError{Op: "empty account deletion", Address: address, Err: err}
+		}
+		if !empty {
+			continue
+		}
+		if err := storage.DeleteAccount(address); err != nil {
+			return &StorageError{Op: "empty account deletion", Address: address, Err: err}
+		}
+	}
+	return nil
+}
+
+// call transfers the value to the recipient and executes its code. Before
+// EIP-158, the recipient is created even if no value is transferred.
+func call(rules params.Rules, block BlockContext, storage Storage, msg *Message, gas uint64, tracer *Tracer) (*EVM, *ExecutionResult) {
+	code, err := storage.GetCode(*msg.To)
+	opts := &ExecutionOpts{
+		contract: *msg.To,
+		sender:   msg.From,
+		value:    msg.Value,
+		calldata: msg.Data,
+		code:     code,
+		gas:      gas,
+	}
+	vm := NewEVM(block, storage, opts, tracer)
+	if err != nil {
+		return vm, &ExecutionResult{Err: &StorageError{Op: "call", Address: *msg.To, Err: err}}
+	}
+
+	snapshot := vm.journal.snapshot()
+	if !rules.IsEIP158 {
+		if err := vm.createAccount(*msg.To); err != nil {
+			return vm, &ExecutionResult{Err: &StorageError{Op: "call", Address: *msg.To, Err: err}}
+		}
+	}
+	if err := vm.transfer(msg.From, *msg.To, msg.Value); err != nil {
+		return vm, vm.halt(snapshot, err)
+	}
+	if len(code) == 0 {
+		return vm, &ExecutionResult{}
+	}
+
+	result := vm.execute()
+	if result.Failed() {
+		if err := vm.journal.revertToSnapshot(storage, snapshot); err != nil {
+			result.Err = &StorageError{Op: "call", Address: *msg.To, Err: err}
+		}
+	}
+	return vm, result
+}
+
+// create deploys a new contract by executing the init code and storing the
+// returned runtime code at the derived address. The account is deleted again
+// if the creation fails, unless it existed before.
+func create(rules params.Rules, block BlockContext, storage Storage, msg *Message, gas uint64, tracer *Tracer) (*EVM, *ExecutionResult) {
+	address := crypto.CreateAddress(msg.From, msg.Nonce)
+	opts := &ExecutionOpts{
+		contract: address,
+		sender:   msg.From,
+		value:    msg.Value,
+		code:     msg.Data,
+		gas:      gas,
+	}
+	vm := NewEVM(block, storage, opts, tracer)
+
+	snapshot := vm.journal.snapshot()
+	if err := vm.createAccount(address); err != nil {
+		return vm, &ExecutionResult{Err: &StorageError{Op: "create", Address: address, Err: err}}
+	}
+	if rules.IsEIP158 {
+		nonce, err := storage.GetNonce(address)
+		if err != nil {
+			return vm, &ExecutionResult{Err: &StorageError{Op: "create", Address: address, Err: err}}
+		}
+		var prev uint64
+		if nonce != nil {
+			prev = *nonce
+		}
+		vm.journal.append(nonceChange{address: address, prev: prev})
+		if err := storage.SetNonce(address, 1); err != nil {
+			return vm, &ExecutionResult{Err: &StorageError{Op: "create", Address: address, Err: err}}
+		}
+	}
+
+	if err := vm.transfer(msg.From, address, msg.Value); err != nil {
+		return vm, vm.halt(snapshot, err)
+	}
+
+	result := vm.execute()
+	if result.Failed() {
+		if err := vm.journal.revertToSnapshot(storage, snapshot); err != nil {
+			result.Err = &StorageError{Op: "create", Address: address, Err: err}
+		}
+		return vm, result
+	}
+
+	// Charge for storing the runtime code
+	code := result.ReturnData
+	if rules.IsEIP158 && len(code) > params.MaxCodeSize {
+		result = vm.halt(snapshot, ErrMaxCodeSize)
+		return vm, result
+	}
+	depositCost := uint64(len(code)) * params.CreateDataGas
+	if vm.executionOpts.gas < depositCost {
+		result = vm.halt(snapshot, ErrCodeStoreOutOfGas)
+		return vm, result
+	}
+	vm.executionOpts.gas -= depositCost
+	if err := storage.SetCode(address, code); err != nil {
+		return vm, vm.halt(snapshot, &StorageError{Op: "create", Address: address, Err: err})
+	}
+
+	// The return data of a successful creation is the deployed code
+	return vm, result
+}
+
+// getBalance returns the balance of the account, zero if it doesn't exist
+func getBalance(storage Storage, address common.Address) (*uint256.Int, error) {
+	balance, err := storage.GetBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	if balance == nil {
+		return new(uint256.Int), nil
+	}
+	return new(uint256.Int).Set(balance), nil
+}
+
+// addBalance credits the account with the amount, creating it if needed
+func addBalance(storage Storage, address common.Address, amount *uint256.Int) error {
+	if amount.IsZero() {
+		return nil
+	}
+	if err := storage.CreateAccount(address); err != nil {
+		return err
+	}
+	balance, err := getBalance(storage, address)
+	if err != nil {
+		return err
+	}
+	return storage.SetBalance(address, balance.Add(balance, amount))
+}
@@ -5,20 +5,44 @@ import (
 	"github.com/holiman/uint256"
 )
 
-// Storage defines base methods that any store needs to implement
+// Storage defines base methods that any store needs to implement. Missing
+// accounts are reported with a nil balance and nonce, the errors are only
+// set if the underlying data can't be read or written. Writable stores
+// create the account implicitly when setting its balance, nonce or code.
 type Storage interface {
 	IsWriteAllowed() bool
 
-	CreateAccount(common.Address)
+	CreateAccount(common.Address) error
+	DeleteAccount(common.Address) error
 
-	SetBalance(common.Address, *uint256.Int)
-	GetBalance(common.Address) *uint256.Int
+	// Exist reports whether the account exists, even if it's empty
+	Exist(common.Address) (bool, error)
+	// Empty reports whether the account is missing or has a zero balance
+	// and nonce and no code, as defined by EIP-161
+	Empty(common.Address) (bool, error)
 
-	SetNonce(common.Address, uint64)
-	GetNonce(common.Address) *uint64
+	SetBalance(common.Address, *uint256.Int) error
+	GetBalance(common.Address) (*uint256.Int, error)
 
-	SetState(common.Address, common.Hash, common.Hash)
-	GetState(common.Address, common.Hash) common.Hash
+	SetNonce(common.Address, uint64) error
+	GetNonce(common.Address) (*uint64, error)
+
+	SetCode(common.Address, []byte) error
+	GetCode(common.Address) ([]byte, error)
+
+	SetState(common.Address, common.Hash, common.Hash) error
+	GetState(common.Address, common.Hash) (common.Hash, error)
 
 	Close()
 }
+
+// StateRooter is implemented by stores which are able to commit to a state root
+type StateRooter interface {
+	StateRoot() (common.Hash, error)
+}
+
+// Committer is implemented by stores which persist their state, the pending
+// changes are committed after each transaction
+type Committer interface {
+	Commit() (common.Hash, error)
+}
@@ -0,0 +1,67 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// StorageSlot is a slot of a contract storage as stored in its trie
+type StorageSlot struct {
+	Hash  common.Hash  `json:"hash"`          // keccak256 of the key, i.e. the path in the trie
+	Key   *common.Hash `json:"key,omitempty"` // nil if the preimage isn't known
+	Value common.Hash  `json:"value"`
+}
+
+// IterateStorage walks the whole storage trie of the account at the opened
+// block in the order of the hashed keys and calls fn for every slot. The
+// original keys are read from the preimages of the database, which geth only
+// stores when running with --cache.preimages.
+//
+// A fresh trie is opened for the iteration, so that other readers aren't
+// blocked for the whole walk.
+func (s *RemoteStorage) IterateStorage(address common.Address, fn func(StorageSlot) error) error {
+	account, _, err := s.getAccount(address)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return fmt.Errorf("account %v not found", address)
+	}
+	reader, err := s.acquireReader()
+	if err != nil {
+		return err
+	}
+	storageTrie, err := s.statedb.OpenStorageTrie(reader.root, address, account.Root, reader.trie)
+	s.releaseReader(reader)
+	if err != nil {
+		return fmt.Errorf("failed to open storage trie %v: %w", account.Root, err)
+	}
+
+	nodeIt, err := storageTrie.NodeIterator(nil)
+	if err != nil {
+		return err
+	}
+	it := trie.NewIterator(nodeIt)
+	for it.Next() {
+		_, content, _, err := rlp.Split(it.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value of slot %x: %w", it.Key, err)
+		}
+		slot := StorageSlot{
+			Hash:  common.BytesToHash(it.Key),
+			Value: common.BytesToHash(content),
+		}
+		if preimage := rawdb.ReadPreimage(s.db, slot.Hash); len(preimage) == common.HashLength {
+			key := common.BytesToHash(preimage)
+			slot.Key = &key
+		}
+		if err := fn(slot); err != nil {
+			return err
+		}
+	}
+	return it.Err
+}
@@ -0,0 +1,400 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// maxBytesLength caps the length of the strings and bytes which are read, so
+// that a corrupted length doesn't read the whole storage
+const maxBytesLength = 1 << 20
+
+// StorageLayout is the storage layout of a contract as emitted by solc, i.e.
+// the storageLayout output selection or --storage-layout
+type StorageLayout struct {
+	Storage []StorageVariable       `json:"storage"`
+	Types   map[string]*StorageType `json:"types"`
+}
+
+// StorageVariable is a state variable or a struct member of a layout. The
+// slot of a struct member is relative to the slot of the struct.
+type StorageVariable struct {
+	Label  string                `json:"label"`
+	Offset uint64                `json:"offset"` // offset in bytes within the slot, from the right
+	Slot   *math.HexOrDecimal256 `json:"slot"`
+	Type   string                `json:"type"`
+}
+
+// StorageType describes how the values of a type are stored
+type StorageType struct {
+	Encoding      string              `json:"encoding"` // inplace, mapping, dynamic_array or bytes
+	Label         string              `json:"label"`
+	NumberOfBytes math.HexOrDecimal64 `json:"numberOfBytes"`
+	Base          string              `json:"base,omitempty"`    // element type of arrays
+	Key           string              `json:"key,omitempty"`     // key type of mappings
+	Value         string              `json:"value,omitempty"`   // value type of mappings
+	Members       []StorageVariable   `json:"members,omitempty"` // members of structs
+}
+
+// StorageValue is a variable read from the storage. Value types, strings and
+// bytes are formatted into the value, while structs, arrays and mappings
+// hold their members, elements or entries.
+type StorageValue struct {
+	Name      string          `json:"name"` // full path, e.g. balances[0x...] or s.values[1]
+	Type      string          `json:"type"` // label of the type, e.g. mapping(address => uint256)
+	Slot      common.Hash     `json:"slot"`
+	Offset    uint64          `json:"offset"`
+	Value     string          `json:"value,omitempty"`
+	Length    uint64          `json:"length,omitempty"`    // number of elements of arrays
+	Truncated bool            `json:"truncated,omitempty"` // whether only the first elements were read
+	Members   []*StorageValue `json:"members,omitempty"`
+}
+
+// StorageDecoder reads the state variables of a contract from any storage
+// using its solc storage layout. Only the entries of the mappings whose keys
+// are given can be read, as the keys aren't part of the storage.
+type StorageDecoder struct {
+	storage     Storage
+	address     common.Address
+	layout      *StorageLayout
+	keys        map[string][][]string // mapping name -> key paths
+	maxElements uint64
+}
+
+// NewStorageDecoder creates a decoder for the contract at the given address.
+// At most maxElements elements of every array are read.
+func NewStorageDecoder(storage Storage, address common.Address, layout *StorageLayout, maxElements uint64) *StorageDecoder {
+	return &StorageDecoder{
+		storage:     storage,
+		address:     address,
+		layout:      layout,
+		keys:        make(map[string][][]string),
+		maxElements: maxElements,
+	}
+}
+
+// AddKeys adds an entry to look up in the mapping with the given name, e.g.
+// balances or s.owners[2]. Nested mappings take one key per level, so that
+// AddKeys("allowance", owner, spender) reads allowance[owner][spender].
+func (d *StorageDecoder) AddKeys(name string, keys ...string) {
+	if len(keys) > 0 {
+		d.keys[name] = append(d.keys[name], keys)
+	}
+}
+
+// Decode reads all the state variables of the layout in order
+func (d *StorageDecoder) Decode() ([]*StorageValue, error) {
+	values := make([]*StorageValue, 0, len(d.layout.Storage))
+	for _, variable := range d.layout.Storage {
+		value, err := d.decodeVariable(variable.Label, variable, new(uint256.Int))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// decodeVariable reads the variable whose slot is relative to the base slot
+func (d *StorageDecoder) decodeVariable(name string, variable StorageVariable, base *uint256.Int) (*StorageValue, error) {
+	if variable.Slot == nil {
+		return nil, fmt.Errorf("missing slot of %s", name)
+	}
+	slot, overflow := uint256.FromBig((*big.Int)(variable.Slot))
+	if overflow {
+		return nil, fmt.Errorf("invalid slot %v of %s", (*big.Int)(variable.Slot), name)
+	}
+	return d.decode(name, variable.Type, slot.Add(slot, base), variable.Offset, nil)
+}
+
+// decode reads the value of the given type at the slot and offset. The key
+// paths are looked up along with the registered ones if it's a mapping.
+func (d *StorageDecoder) decode(name string, typeID string, slot *uint256.Int, offset uint64, keys [][]string) (*StorageValue, error) {
+	typ, ok := d.layout.Types[typeID]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s of %s", typeID, name)
+	}
+	value := &StorageValue{Name: name, Type: typ.Label, Slot: slot.Bytes32(), Offset: offset}
+
+	var err error
+	switch typ.Encoding {
+	case "inplace":
+		// Arrays of structs are labeled as structs too, e.g. struct C.S[2]
+		switch {
+		case typ.Base != "":
+			var length uint64
+			if length, err = arrayLength(typ.Label); err == nil {
+				err = d.decodeElements(value, typ.Base, slot, length)
+			}
+		case strings.HasPrefix(typ.Label, "struct "):
+			err = d.decodeStruct(value, typ, slot)
+		default:
+			value.Value, err = d.decodeValueType(typ, slot, offset)
+		}
+	case "dynamic_array":
+		var word common.Hash
+		if word, err = d.getState(slot); err != nil {
+			break
+		}
+		length := new(uint256.Int).SetBytes(word.Bytes())
+		if !length.IsUint64() {
+			err = fmt.Errorf("invalid length %v", length)
+			break
+		}
+		start := new(uint256.Int).SetBytes(crypto.Keccak256(value.Slot.Bytes()))
+		err = d.decodeElements(value, typ.Base, start, length.Uint64())
+	case "bytes":
+		value.Value, err = d.decodeBytes(typ, slot)
+	case "mapping":
+		paths := make([][]string, 0, len(d.keys[name])+len(keys))
+		paths = append(append(paths, d.keys[name]...), keys...)
+		err = d.decodeMapping(value, typ, paths)
+	default:
+		err = fmt.Errorf("unknown encoding %s", typ.Encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// getState reads the slot of the contract
+func (d *StorageDecoder) getState(slot *uint256.Int) (common.Hash, error) {
+	return d.storage.GetState(d.address, slot.Bytes32())
+}
+
+// decodeValueType reads a value type, which may share its slot with others
+func (d *StorageDecoder) decodeValueType(typ *StorageType, slot *uint256.Int, offset uint64) (string, error) {
+	size := uint64(typ.NumberOfBytes)
+	if size == 0 || offset+size > common.HashLength {
+		return "", fmt.Errorf("invalid size %d at offset %d", size, offset)
+	}
+	word, err := d.getState(slot)
+	if err != nil {
+		return "", err
+	}
+	return formatValue(typ.Label, word[common.HashLength-offset-size:common.HashLength-offset]), nil
+}
+
+// decodeStruct reads the members of the struct, whose slots are relative to
+// the slot of the struct
+func (d *StorageDecoder) decodeStruct(value *StorageValue, typ *StorageType, slot *uint256.Int) error {
+	for _, member := range typ.Members {
+		decoded, err := d.decodeVariable(value.Name+"."+member.Label, member, slot)
+		if err != nil {
+			return err
+		}
+		value.Members = append(value.Members, decoded)
+	}
+	return nil
+}
+
+// decodeElements reads the elements of an array starting at the given slot.
+// Elements smaller than a slot are packed, the others start at a new slot.
+func (d *StorageDecoder) decodeElements(value *StorageValue, baseID string, start *uint256.Int, length uint64) error {
+	base, ok := d.layout.Types[baseID]
+	if !ok {
+		return fmt.Errorf("unknown type %s", baseID)
+	}
+	size := uint64(base.NumberOfBytes)
+	if size == 0 {
+		return fmt.Errorf("invalid size of %s", baseID)
+	}
+	count := min(length, d.maxElements)
+	value.Length, value.Truncated = length, count < length
+
+	for i := uint64(0); i < count; i++ {
+		var slot, offset uint64
+		if size < common.HashLength {
+			perSlot := common.HashLength / size
+			slot, offset = i/perSlot, (i%perSlot)*size
+		} else {
+			slot = i * ((size + common.HashLength - 1) / common.HashLength)
+		}
+		elementSlot := new(uint256.Int).AddUint64(start, slot)
+		element, err := d.decode(fmt.Sprintf("%s[%d]", value.Name, i), baseID, elementSlot, offset, nil)
+		if err != nil {
+			return err
+		}
+		value.Members = append(value.Members, element)
+	}
+	return nil
+}
+
+// decodeBytes reads a string or bytes. Short values are stored in the slot
+// along with their length, long ones in consecutive slots starting at the
+// hash of the slot.
+func (d *StorageDecoder) decodeBytes(typ *StorageType, slot *uint256.Int) (string, error) {
+	word, err := d.getState(slot)
+	if err != nil {
+		return "", err
+	}
+	var data []byte
+	if word[common.HashLength-1]&1 == 0 {
+		length := word[common.HashLength-1] / 2
+		if length >= common.HashLength {
+			return "", fmt.Errorf("invalid short length %d", length)
+		}
+		data = word[:length]
+	} else {
+		length := new(uint256.Int).SetBytes(word.Bytes())
+		length.Rsh(length, 1)
+		if !length.IsUint64() || length.Uint64() > maxBytesLength {
+			return "", fmt.Errorf("invalid length %v", length)
+		}
+		data = make([]byte, 0, length.Uint64())
+		hash := slot.Bytes32()
+		start := new(uint256.Int).SetBytes(crypto.Keccak256(hash[:]))
+		for i := uint64(0); uint64(len(data)) < length.Uint64(); i++ {
+			chunk, err := d.getState(new(uint256.Int).AddUint64(start, i))
+			if err != nil {
+				return "", err
+			}
+			data = append(data, chunk[:min(common.HashLength, length.Uint64()-uint64(len(data)))]...)
+		}
+	}
+	if typ.Label == "string" {
+		return strconv.Quote(string(data)), nil
+	}
+	return hexutil.Encode(data), nil
+}
+
+// decodeMapping reads the entries of the given keys. The first key of each
+// path selects the entry, the rest are passed on to nested mappings.
+func (d *StorageDecoder) decodeMapping(value *StorageValue, typ *StorageType, keys [][]string) error {
+	keyType, ok := d.layout.Types[typ.Key]
+	if !ok {
+		return fmt.Errorf("unknown type %s", typ.Key)
+	}
+	var (
+		order   []string
+		encoded = make(map[string][]byte)
+		nested  = make(map[string][][]string)
+	)
+	for _, path := range keys {
+		key, label, err := encodeKey(keyType.Label, path[0])
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %w", path[0], err)
+		}
+		if _, ok := encoded[label]; !ok {
+			order = append(order, label)
+			encoded[label] = key
+		}
+		if len(path) > 1 {
+			nested[label] = append(nested[label], path[1:])
+		}
+	}
+	for _, label := range order {
+		entrySlot := new(uint256.Int).SetBytes(crypto.Keccak256(encoded[label], value.Slot.Bytes()))
+		entry, err := d.decode(fmt.Sprintf("%s[%s]", value.Name, label), typ.Value, entrySlot, 0, nested[label])
+		if err != nil {
+			return err
+		}
+		value.Members = append(value.Members, entry)
+	}
+	return nil
+}
+
+// arrayLength returns the length of a fixed size array from its label, e.g.
+// 3 for uint8[3] or 2 for uint8[3][2]
+func arrayLength(label string) (uint64, error) {
+	start := strings.LastIndex(label, "[")
+	if start < 0 || !strings.HasSuffix(label, "]") {
+		return 0, fmt.Errorf("invalid array type %s", label)
+	}
+	return strconv.ParseUint(label[start+1:len(label)-1], 10, 64)
+}
+
+// isFixedBytes returns whether the label is a fixed size byte array, e.g. bytes32
+func isFixedBytes(label string) bool {
+	size, err := strconv.Atoi(strings.TrimPrefix(label, "bytes"))
+	return strings.HasPrefix(label, "bytes") && err == nil && size > 0 && size <= common.HashLength
+}
+
+// isAddress returns whether the label is an address or a contract type
+func isAddress(label string) bool {
+	return label == "address" || label == "address payable" || strings.HasPrefix(label, "contract ")
+}
+
+// formatValue formats the bytes of a value type with the given label
+func formatValue(label string, data []byte) string {
+	switch {
+	case isAddress(label):
+		return common.BytesToAddress(data).Hex()
+	case label == "bool":
+		return strconv.FormatBool(data[len(data)-1] != 0)
+	case strings.HasPrefix(label, "uint"), strings.HasPrefix(label, "enum "):
+		return new(big.Int).SetBytes(data).String()
+	case strings.HasPrefix(label, "int"):
+		value := new(big.Int).SetBytes(data)
+		if data[0]&0x80 != 0 {
+			value.Sub(value, new(big.Int).Lsh(common.Big1, uint(8*len(data))))
+		}
+		return value.String()
+	default:
+		// Fixed size byte arrays, function types and anything else
+		return hexutil.Encode(data)
+	}
+}
+
+// encodeKey encodes the key of a mapping as hashed into the slot of its entry
+// along with its canonical form, used in the name of the entry. Value types
+// are padded to 32 bytes, strings and bytes are hashed as is.
+func encodeKey(label string, key string) ([]byte, string, error) {
+	switch {
+	case isAddress(label):
+		if !common.IsHexAddress(key) {
+			return nil, "", fmt.Errorf("invalid address")
+		}
+		address := common.HexToAddress(key)
+		return common.LeftPadBytes(address.Bytes(), common.HashLength), address.Hex(), nil
+	case label == "bool":
+		value, err := strconv.ParseBool(key)
+		if err != nil {
+			return nil, "", err
+		}
+		encoded := make([]byte, common.HashLength)
+		if value {
+			encoded[common.HashLength-1] = 1
+		}
+		return encoded, strconv.FormatBool(value), nil
+	case strings.HasPrefix(label, "uint"), strings.HasPrefix(label, "enum "):
+		value, ok := math.ParseBig256(key)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid unsigned integer")
+		}
+		return math.U256Bytes(new(big.Int).Set(value)), value.String(), nil
+	case strings.HasPrefix(label, "int"):
+		value, ok := new(big.Int).SetString(key, 0)
+		if !ok || value.BitLen() > 255 {
+			return nil, "", fmt.Errorf("invalid signed integer")
+		}
+		return math.U256Bytes(new(big.Int).Set(value)), value.String(), nil
+	case isFixedBytes(label):
+		size, _ := strconv.Atoi(strings.TrimPrefix(label, "bytes"))
+		value, err := hexutil.Decode(key)
+		if err != nil || len(value) > size {
+			return nil, "", fmt.Errorf("invalid %s", label)
+		}
+		value = common.RightPadBytes(value, size)
+		return common.RightPadBytes(value, common.HashLength), hexutil.Encode(value), nil
+	case label == "string":
+		return []byte(key), strconv.Quote(key), nil
+	case label == "bytes":
+		value, err := hexutil.Decode(key)
+		if err != nil {
+			return nil, "", err
+		}
+		return value, hexutil.Encode(value), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %s", label)
+	}
+}
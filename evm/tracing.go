@@ -3,6 +3,7 @@ package evm
 import (
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/holiman/uint256"
 )
@@ -15,6 +16,12 @@ type Tracer struct {
 
 	storageReadTrace  []interface{}
 	storageWriteTrace []interface{}
+
+	// Step recording, used for comparing executions step by step
+	recordSteps bool                                           // record the steps instead of logging them
+	contract    common.Address                                 // contract being executed
+	steps       []StepLog                                      // recorded steps
+	storage     map[common.Address]map[common.Hash]common.Hash // slots accessed so far, per contract
 }
 
 type StackTrace struct {
@@ -25,6 +32,17 @@ type MemoryTrace struct {
 	memory *Memory
 }
 
+// StepLog is a snapshot of the interpreter state taken right before an
+// opcode is executed. It's modelled after geth's struct logs.
+type StepLog struct {
+	Pc      uint64
+	Op      OpCode
+	Gas     uint64
+	Stack   []uint256.Int
+	Memory  []byte
+	Storage map[common.Hash]common.Hash // only set for SLOAD and SSTORE
+}
+
 func NewTracer() *Tracer {
 	return &Tracer{
 		storageReadTrace:  make([]interface{}, 0),
@@ -32,19 +50,48 @@ func NewTracer() *Tracer {
 	}
 }
 
+// NewStepTracer returns a tracer which silently records a snapshot of every
+// step instead of logging it. The steps can be fetched using `Steps`.
+func NewStepTracer() *Tracer {
+	return &Tracer{
+		storageReadTrace:  make([]interface{}, 0),
+		storageWriteTrace: make([]interface{}, 0),
+		recordSteps:       true,
+		steps:             make([]StepLog, 0),
+		storage:           make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+// Steps returns the steps recorded by a step tracer
+func (t *Tracer) Steps() []StepLog {
+	return t.steps
+}
+
 func (t *Tracer) CaptureTxStart(opts *ExecutionOpts) {
+	t.contract = opts.contract
+	if t.recordSteps {
+		return
+	}
 	log.Info("### Starting trace")
 	log.Info("### Transaction details", "from", opts.sender, "contract", opts.contract, "value", opts.value.Uint64(), "gas", opts.gas)
 	fmt.Println("")
 }
 
 func (t *Tracer) CaptureTxEnd(opts *ExecutionOpts) {
+	if t.recordSteps {
+		return
+	}
 	log.Info("### Execution completed", "gas left", opts.gas)
 	log.Info("### Ending trace")
 	fmt.Println("")
 }
 
-func (t *Tracer) CaptureOpCodeStart(scope ScopeContext, opcode OpCode, gasCost uint64) {
+func (t *Tracer) CaptureOpCodeStart(scope ScopeContext, pc uint64, opcode OpCode, gasCost uint64) {
+	if t.recordSteps {
+		t.captureStep(scope, pc, opcode, gasCost)
+		return
+	}
+
 	// Capture stack
 	stackTrace := StackTrace{
 		stack: &Stack{items: make([]uint256.Int, scope.stack.len())},
@@ -63,7 +110,45 @@ func (t *Tracer) CaptureOpCodeStart(scope ScopeContext, opcode OpCode, gasCost u
 	t.gasCost = gasCost
 }
 
+// captureStep records a snapshot of the interpreter state before executing
+// the opcode. Similar to geth, the accessed slots are tracked for SLOAD and
+// SSTORE and a copy of them is attached to the step.
+func (t *Tracer) captureStep(scope ScopeContext, pc uint64, opcode OpCode, gas uint64) {
+	step := StepLog{
+		Pc:     pc,
+		Op:     opcode,
+		Gas:    gas,
+		Stack:  make([]uint256.Int, scope.stack.len()),
+		Memory: make([]byte, scope.memory.Len()),
+	}
+	copy(step.Stack, scope.stack.items)
+	copy(step.Memory, scope.memory.data)
+
+	stackLen := scope.stack.len()
+	if (opcode == SLOAD || opcode == SSTORE) && t.storage[t.contract] == nil {
+		t.storage[t.contract] = make(map[common.Hash]common.Hash)
+	}
+	if opcode == SLOAD && stackLen >= 1 {
+		key := common.Hash(scope.stack.items[stackLen-1].Bytes32())
+		// A failing read halts the execution, the step is recorded without it
+		if value, err := scope.storage.GetState(t.contract, key); err == nil {
+			t.storage[t.contract][key] = value
+		}
+		step.Storage = copyStorage(t.storage[t.contract])
+	} else if opcode == SSTORE && stackLen >= 2 {
+		key := common.Hash(scope.stack.items[stackLen-1].Bytes32())
+		t.storage[t.contract][key] = common.Hash(scope.stack.items[stackLen-2].Bytes32())
+		step.Storage = copyStorage(t.storage[t.contract])
+	}
+
+	t.steps = append(t.steps, step)
+}
+
 func (t *Tracer) CaptureOpCodeEnd(scope ScopeContext, gasLeft uint64) {
+	if t.recordSteps {
+		return
+	}
+
 	log.Info("### Opcode Trace", "opcode", t.opcode, "gas used", t.gasCost-gasLeft, "gas left", gasLeft)
 	t.stackTrace.stack.Print("### Stack before")
 	scope.stack.Print("### Stack after")
@@ -85,6 +170,9 @@ func (t *Tracer) CaptureOpCodeEnd(scope ScopeContext, gasLeft uint64) {
 }
 
 func (t *Tracer) CaptureAccountCreation(ctx ...interface{}) {
+	if t.recordSteps {
+		return
+	}
 	log.Info("***** Account created", ctx...)
 	fmt.Println("")
 }
@@ -96,3 +184,11 @@ func (t *Tracer) CaptureStorageReads(ctx ...interface{}) {
 func (t *Tracer) CaptureStorageWrites(ctx ...interface{}) {
 	t.storageWriteTrace = ctx
 }
+
+func copyStorage(storage map[common.Hash]common.Hash) map[common.Hash]common.Hash {
+	cpy := make(map[common.Hash]common.Hash, len(storage))
+	for key, value := range storage {
+		cpy[key] = value
+	}
+	return cpy
+}
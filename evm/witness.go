@@ -0,0 +1,145 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// StartWitness starts recording every trie node, code and header read from
+// the opened state into a witness for the given block, which must be a child
+// of the opened one. The caches are dropped and the snapshot is bypassed, so
+// that every read goes through the tries.
+func (s *RemoteStorage) StartWitness(block *types.Block) error {
+	header := s.Header()
+	if block.ParentHash() != header.Hash() {
+		return fmt.Errorf("block %d is not a child of the opened block %d", block.NumberU64(), header.Number.Uint64())
+	}
+	if err := s.openState(header); err != nil {
+		return err
+	}
+	witness, err := stateless.NewWitness(s, block)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.snap = nil
+	s.witness = witness
+	return nil
+}
+
+// Witness returns the witness recorded so far through all the handles on the
+// tries, nil if not recording. It mustn't be called while reads are running.
+func (s *RemoteStorage) Witness() *stateless.Witness {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.witness == nil {
+		return nil
+	}
+	for _, reader := range s.readers {
+		s.witness.AddState(reader.trie.Witness())
+		for _, storageTrie := range reader.storageTries {
+			s.witness.AddState(storageTrie.Witness())
+		}
+	}
+	return s.witness
+}
+
+// WitnessStorage represents a read only store backed by a witness alone. The
+// state is read from the trie nodes and codes of the witness, any read which
+// isn't covered by it fails with a missing trie node error.
+type WitnessStorage struct {
+	*RemoteStorage
+	witness *stateless.Witness
+}
+
+// NewWitnessStorage opens the state of the witness at the pre-state root,
+// i.e. the root of the parent header. The chain of headers is checked to
+// link up to the block.
+func NewWitnessStorage(witness *stateless.Witness, tracer *Tracer) (*WitnessStorage, error) {
+	if len(witness.Headers) == 0 {
+		return nil, errors.New("witness without parent header")
+	}
+	child := witness.Block.Header()
+	for i, header := range witness.Headers {
+		if header == nil || header.Hash() != child.ParentHash {
+			return nil, fmt.Errorf("witness header %d doesn't link to its child", i)
+		}
+		child = header
+	}
+
+	db := witness.MakeHashDB()
+	trieDb := triedb.NewDatabase(db, triedb.HashDefaults)
+	storage := &RemoteStorage{
+		db:      db,
+		triedb:  trieDb,
+		statedb: state.NewDatabaseWithNodeDB(db, trieDb),
+		tracer:  tracer,
+	}
+	if err := storage.openState(witness.Headers[0]); err != nil {
+		trieDb.Close()
+		db.Close()
+		return nil, fmt.Errorf("witness doesn't contain the pre-state root %v: %w", witness.Root(), err)
+	}
+
+	log.Info("Opened witness", "number", witness.Block.NumberU64(), "root", witness.Root(), "nodes", len(witness.State), "codes", len(witness.Codes), "headers", len(witness.Headers))
+
+	return &WitnessStorage{RemoteStorage: storage, witness: witness}, nil
+}
+
+// GetHash returns the hash of the block with the given number, read from
+// the headers of the witness. The zero hash is returned if it's not covered.
+func (s *WitnessStorage) GetHash(number uint64) common.Hash {
+	parent := s.witness.Block.NumberU64() - 1
+	if number > parent || parent-number >= uint64(len(s.witness.Headers)) {
+		return common.Hash{}
+	}
+	return s.witness.Headers[parent-number].Hash()
+}
+
+// ExecuteWitness re-executes the transaction with the given hash from the
+// witness alone, applying the preceding transactions of the witness block
+// first. The local receipt is compared against the expected one if given,
+// e.g. the receipt of the replay which recorded the witness.
+func ExecuteWitness(config *params.ChainConfig, witness *stateless.Witness, hash common.Hash, expected *types.Receipt, tracer *Tracer) (*ReplayResult, error) {
+	index := -1
+	for i, tx := range witness.Block.Transactions() {
+		if tx.Hash() == hash {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("transaction %v not found in witness block %d", hash, witness.Block.NumberU64())
+	}
+	storage, err := NewWitnessStorage(witness, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	result, local, err := applyTransactions(config, witness.Block, storage, storage.GetHash, uint64(index), tracer)
+	if err != nil {
+		return nil, err
+	}
+	replay := &ReplayResult{
+		Result:   result,
+		Local:    local,
+		Expected: expected,
+	}
+	if expected != nil {
+		replay.Diffs = compareReceipts(local, expected)
+	}
+	return replay, nil
+}
@@ -12,12 +12,12 @@ import (
 var (
 	StorageFlag = &cli.StringFlag{
 		Name:  "storage",
-		Usage: "Type of storage to use for simulation (simple/remote)",
+		Usage: "Type of storage to use for simulation (simple/remote/rpc/disk)",
 		Value: "simple",
 	}
 	Datadir = &cli.StringFlag{
 		Name:  "datadir",
-		Usage: "Path to use for opening remote geth database",
+		Usage: "Path to use for opening remote geth database or the disk storage",
 		Value: "",
 	}
 	ContractAddressFlag = &cli.StringFlag{
@@ -25,6 +25,113 @@ var (
 		Usage: "Contract address to be used for remote simulation",
 		Value: "",
 	}
+	RPCURLFlag = &cli.StringFlag{
+		Name:  "rpc-url",
+		Usage: "Url of the JSON-RPC endpoint to use for rpc storage",
+		Value: "",
+	}
+	BlockFlag = &cli.StringFlag{
+		Name:  "block",
+		Usage: "Number or hash of the block to open the remote state at (defaults to the latest block)",
+		Value: "",
+	}
+	TxHashFlag = &cli.StringFlag{
+		Name:  "tx",
+		Usage: "Hash of the transaction to be replayed",
+		Value: "",
+	}
+	CodeFlag = &cli.StringFlag{
+		Name:  "code",
+		Usage: "Hex encoded code of the contract (only for simple storage)",
+		Value: "",
+	}
+	CalldataFlag = &cli.StringFlag{
+		Name:  "calldata",
+		Usage: "Hex encoded calldata to be sent to the contract",
+		Value: "",
+	}
+	StateOverridesFlag = &cli.StringFlag{
+		Name:  "state-overrides",
+		Usage: "Path to a json file with the state overrides (same format as eth_call)",
+		Value: "",
+	}
+	BlockOverridesFlag = &cli.StringFlag{
+		Name:  "block-overrides",
+		Usage: "Path to a json file with the block overrides (number, time, gasLimit, coinbase, baseFee)",
+		Value: "",
+	}
+	BundleFlag = &cli.StringFlag{
+		Name:  "bundle",
+		Usage: "Path to a json file with the ordered list of messages (from, to, value, gas, data)",
+		Value: "",
+	}
+	AbortOnFailureFlag = &cli.BoolFlag{
+		Name:  "abort-on-failure",
+		Usage: "Stop the bundle at the first message which fails or reverts",
+	}
+	WorkersFlag = &cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of messages simulated concurrently (one per cpu if 0)",
+		Value: 0,
+	}
+	WitnessOutFlag = &cli.StringFlag{
+		Name:  "out",
+		Usage: "Path to write the rlp encoded witness to",
+		Value: "",
+	}
+	StateDiffFlag = &cli.StringFlag{
+		Name:  "state-diff",
+		Usage: "Path to write the state diff to (same format as geth's prestateTracer in diffMode)",
+		Value: "",
+	}
+	AddressFlag = &cli.StringFlag{
+		Name:  "address",
+		Usage: "Address of the account to prove or dump",
+		Value: "",
+	}
+	KeyFlag = &cli.StringSliceFlag{
+		Name:  "key",
+		Usage: "Storage slot to prove, can be repeated",
+	}
+	StorageFormatFlag = &cli.StringFlag{
+		Name:  "format",
+		Usage: "Format of the storage dump (json/csv)",
+		Value: "json",
+	}
+	StorageOutFlag = &cli.StringFlag{
+		Name:  "out",
+		Usage: "Path to write the storage dump to (printed if not set)",
+		Value: "",
+	}
+	StateFlag = &cli.StringFlag{
+		Name:  "state",
+		Usage: "Path to a genesis file, genesis alloc or geth dump to seed the simple storage with",
+		Value: "",
+	}
+	DumpStateFlag = &cli.StringFlag{
+		Name:  "dump-state",
+		Usage: "Path to write the final state of the simple storage to",
+		Value: "",
+	}
+	DumpFormatFlag = &cli.StringFlag{
+		Name:  "dump-format",
+		Usage: "Format of the written state (alloc/dump)",
+		Value: "alloc",
+	}
+	LayoutFlag = &cli.StringFlag{
+		Name:  "layout",
+		Usage: "Path to the solc storage layout of the contract (storageLayout output)",
+		Value: "",
+	}
+	MappingKeyFlag = &cli.StringSliceFlag{
+		Name:  "key",
+		Usage: "Mapping entry to read as name=key (name=key1,key2 for nested mappings), can be repeated",
+	}
+	MaxElementsFlag = &cli.Uint64Flag{
+		Name:  "max-elements",
+		Usage: "Maximum number of elements read from every array",
+		Value: 100,
+	}
 	simulateCommand = &cli.Command{
 		Name:   "simulate",
 		Usage:  "Simulate EVM opcodes",
@@ -32,7 +139,153 @@ var (
 		Flags: []cli.Flag{
 			StorageFlag,
 			Datadir,
+			BlockFlag,
 			ContractAddressFlag,
+			StateFlag,
+			DumpStateFlag,
+			DumpFormatFlag,
+		},
+	}
+	replayCommand = &cli.Command{
+		Name:   "replay",
+		Usage:  "Replay a historical transaction from a geth datadir and compare it with its receipt",
+		Action: runReplay,
+		Flags: []cli.Flag{
+			Datadir,
+			TxHashFlag,
+		},
+	}
+	diffCommand = &cli.Command{
+		Name:   "diff",
+		Usage:  "Compare the execution of goevm against geth's core/vm step by step",
+		Action: runDiff,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			ContractAddressFlag,
+			CodeFlag,
+			CalldataFlag,
+		},
+	}
+	callCommand = &cli.Command{
+		Name:   "call",
+		Usage:  "Execute an eth_call style message with optional state and block overrides",
+		Action: runCall,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			ContractAddressFlag,
+			CalldataFlag,
+			StateOverridesFlag,
+			BlockOverridesFlag,
+			StateDiffFlag,
+		},
+	}
+	estimateCommand = &cli.Command{
+		Name:   "estimate",
+		Usage:  "Estimate the gas required by a message using binary search",
+		Action: runEstimate,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			ContractAddressFlag,
+			CalldataFlag,
+			StateOverridesFlag,
+		},
+	}
+	accessListCommand = &cli.Command{
+		Name:   "access-list",
+		Usage:  "Generate an EIP-2930 access list for a message",
+		Action: runAccessList,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			ContractAddressFlag,
+			CalldataFlag,
+		},
+	}
+	bundleCommand = &cli.Command{
+		Name:   "bundle",
+		Usage:  "Simulate an ordered bundle of messages with shared state",
+		Action: runBundle,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			BundleFlag,
+			AbortOnFailureFlag,
+			StateDiffFlag,
+		},
+	}
+	parallelCommand = &cli.Command{
+		Name:   "parallel",
+		Usage:  "Simulate independent messages concurrently on top of the same state",
+		Action: runParallel,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			BundleFlag,
+			WorkersFlag,
+		},
+	}
+	witnessCommand = &cli.Command{
+		Name:   "witness",
+		Usage:  "Record the stateless witness of a historical transaction and re-execute it from the witness alone",
+		Action: runWitness,
+		Flags: []cli.Flag{
+			Datadir,
+			TxHashFlag,
+			WitnessOutFlag,
+		},
+	}
+	proofCommand = &cli.Command{
+		Name:   "proof",
+		Usage:  "Generate and verify the merkle proofs of an account and its storage slots (eth_getProof)",
+		Action: runProof,
+		Flags: []cli.Flag{
+			Datadir,
+			BlockFlag,
+			AddressFlag,
+			KeyFlag,
+		},
+	}
+	storageDumpCommand = &cli.Command{
+		Name:   "storage-dump",
+		Usage:  "Dump the whole storage of a contract from a geth datadir, along with the original keys if their preimages are known",
+		Action: runStorageDump,
+		Flags: []cli.Flag{
+			Datadir,
+			BlockFlag,
+			AddressFlag,
+			StorageFormatFlag,
+			StorageOutFlag,
+		},
+	}
+	layoutCommand = &cli.Command{
+		Name:   "layout",
+		Usage:  "Decode the state variables of a contract using its solc storage layout",
+		Action: runLayout,
+		Flags: []cli.Flag{
+			StorageFlag,
+			Datadir,
+			RPCURLFlag,
+			BlockFlag,
+			ContractAddressFlag,
+			StateFlag,
+			LayoutFlag,
+			MappingKeyFlag,
+			MaxElementsFlag,
 		},
 	}
 )
@@ -41,7 +294,9 @@ func initSimulator() *cli.App {
 	app := cli.NewApp()
 	app.Name = "evm-simulator"
 	app.Usage = "Simulate EVM opcodes"
-	app.Commands = []*cli.Command{simulateCommand}
+	// Repeated flags are used for lists, commas are part of nested mapping keys
+	app.DisableSliceFlagSeparator = true
+	app.Commands = []*cli.Command{simulateCommand, replayCommand, diffCommand, callCommand, estimateCommand, accessListCommand, bundleCommand, parallelCommand, proofCommand, witnessCommand, storageDumpCommand, layoutCommand}
 	return app
 }
 
@@ -58,7 +313,17 @@ func main() {
 func runSimulator(c *cli.Context) error {
 	storageType := c.String("storage")
 	if storageType == "simple" {
-		simulation.RunSimpleSimulation()
+		simulation.RunSimpleSimulation(c.String("state"), c.String("dump-state"), c.String("dump-format"))
+		return nil
+	}
+
+	if storageType == "disk" {
+		path := c.String("datadir")
+		if path == "" {
+			log.Error("Datadir is required for disk simulation")
+			return nil
+		}
+		simulation.RunDiskSimulation(path)
 		return nil
 	}
 
@@ -69,10 +334,176 @@ func runSimulator(c *cli.Context) error {
 			log.Error("Contract address and datadir are required for remote simulation")
 			return nil
 		}
-		simulation.RunRemoteSimulation(path, contractAddress)
+		simulation.RunRemoteSimulation(path, c.String("block"), contractAddress)
 		return nil
 	}
 
 	log.Error("Invalid simulation type, exiting")
 	return nil
 }
+
+func runReplay(c *cli.Context) error {
+	txHash := c.String("tx")
+	path := c.String("datadir")
+	if txHash == "" || path == "" {
+		log.Error("Transaction hash and datadir are required for replay")
+		return nil
+	}
+	simulation.RunReplay(path, txHash)
+	return nil
+}
+
+func runDiff(c *cli.Context) error {
+	storageType := c.String("storage")
+	contractAddress := c.String("contract-address")
+	if storageType == "simple" {
+		if c.String("code") == "" {
+			log.Error("Code is required for simple diff")
+			return nil
+		}
+		if contractAddress == "" {
+			contractAddress = "0x1000"
+		}
+	}
+	if storageType != "simple" && (contractAddress == "" || storagePath(c) == "") {
+		log.Error("Contract address and datadir or rpc url are required for diff")
+		return nil
+	}
+	simulation.RunDiff(storageType, storagePath(c), c.String("block"), contractAddress, c.String("code"), c.String("calldata"))
+	return nil
+}
+
+func runCall(c *cli.Context) error {
+	storageType := c.String("storage")
+	contractAddress := c.String("contract-address")
+	path := storagePath(c)
+	if contractAddress == "" {
+		log.Error("Contract address is required for call")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for call")
+		return nil
+	}
+	simulation.RunCall(storageType, path, c.String("block"), contractAddress, c.String("calldata"), c.String("state-overrides"), c.String("block-overrides"), c.String("state-diff"))
+	return nil
+}
+
+func runEstimate(c *cli.Context) error {
+	storageType := c.String("storage")
+	contractAddress := c.String("contract-address")
+	path := storagePath(c)
+	if contractAddress == "" {
+		log.Error("Contract address is required for estimate")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for estimate")
+		return nil
+	}
+	simulation.RunEstimate(storageType, path, c.String("block"), contractAddress, c.String("calldata"), c.String("state-overrides"))
+	return nil
+}
+
+func runAccessList(c *cli.Context) error {
+	storageType := c.String("storage")
+	contractAddress := c.String("contract-address")
+	path := storagePath(c)
+	if contractAddress == "" {
+		log.Error("Contract address is required for access list")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for access list")
+		return nil
+	}
+	simulation.RunAccessList(storageType, path, c.String("block"), contractAddress, c.String("calldata"))
+	return nil
+}
+
+func runBundle(c *cli.Context) error {
+	storageType := c.String("storage")
+	path := storagePath(c)
+	if c.String("bundle") == "" {
+		log.Error("Bundle is required for bundle simulation")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for bundle simulation")
+		return nil
+	}
+	simulation.RunBundle(storageType, path, c.String("block"), c.String("bundle"), c.Bool("abort-on-failure"), c.String("state-diff"))
+	return nil
+}
+
+func runParallel(c *cli.Context) error {
+	storageType := c.String("storage")
+	path := storagePath(c)
+	if c.String("bundle") == "" {
+		log.Error("Messages are required for parallel simulation")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for parallel simulation")
+		return nil
+	}
+	simulation.RunParallel(storageType, path, c.String("block"), c.String("bundle"), c.Int("workers"))
+	return nil
+}
+
+func runWitness(c *cli.Context) error {
+	txHash := c.String("tx")
+	path := c.String("datadir")
+	if txHash == "" || path == "" {
+		log.Error("Transaction hash and datadir are required for witness recording")
+		return nil
+	}
+	simulation.RunWitness(path, txHash, c.String("out"))
+	return nil
+}
+
+func runProof(c *cli.Context) error {
+	address := c.String("address")
+	path := c.String("datadir")
+	if address == "" || path == "" {
+		log.Error("Address and datadir are required for proof")
+		return nil
+	}
+	simulation.RunProof(path, c.String("block"), address, c.StringSlice("key"))
+	return nil
+}
+
+func runStorageDump(c *cli.Context) error {
+	address := c.String("address")
+	path := c.String("datadir")
+	if address == "" || path == "" {
+		log.Error("Address and datadir are required for storage dump")
+		return nil
+	}
+	simulation.RunStorageDump(path, c.String("block"), address, c.String("format"), c.String("out"))
+	return nil
+}
+
+func runLayout(c *cli.Context) error {
+	storageType := c.String("storage")
+	contractAddress := c.String("contract-address")
+	path := storagePath(c)
+	if contractAddress == "" || c.String("layout") == "" {
+		log.Error("Contract address and layout are required for layout")
+		return nil
+	}
+	if storageType != "simple" && path == "" {
+		log.Error("Datadir or rpc url is required for layout")
+		return nil
+	}
+	simulation.RunLayout(storageType, path, c.String("block"), contractAddress, c.String("layout"), c.String("state"), c.StringSlice("key"), c.Uint64("max-elements"))
+	return nil
+}
+
+// storagePath returns the url of the endpoint for rpc storage and the datadir otherwise
+func storagePath(c *cli.Context) string {
+	if c.String("storage") == "rpc" {
+		return c.String("rpc-url")
+	}
+	return c.String("datadir")
+}
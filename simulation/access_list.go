@@ -0,0 +1,48 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunAccessList generates the EIP-2930 access list for a message against the
+// contract on top of the given storage and prints it as json
+func RunAccessList(storageType string, path string, blockID string, contractAddress string, calldata string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	// Initialise the contract address
+	contract := common.HexToAddress(contractAddress)
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	msg := &evm.Message{
+		From: sender,
+		To:   &contract,
+		Data: common.FromHex(calldata),
+	}
+
+	log.Info("Creating access list", "contract", contract)
+	result, err := evm.CreateAccessList(config, block, storage, msg)
+	if err != nil {
+		log.Error("Unable to create access list", "err", err)
+		return
+	}
+	log.Info("Access list created", "entries", len(result.AccessList), "gas used", result.GasUsed, "gas used without", result.GasUsedWithout, "err", result.Err)
+
+	out, err := json.MarshalIndent(result.AccessList, "", "  ")
+	if err != nil {
+		log.Error("Unable to encode access list", "err", err)
+		return
+	}
+	fmt.Println(string(out))
+}
@@ -0,0 +1,135 @@
+package simulation
+
+import (
+	"fmt"
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/uint256"
+)
+
+// bundleMessage is a message of a bundle as read from json, similar to the
+// arguments of eth_call
+type bundleMessage struct {
+	From  *common.Address `json:"from"` // defaults to the simulation sender
+	To    *common.Address `json:"to"`   // nil for contract creation
+	Value *hexutil.Big    `json:"value"`
+	Gas   hexutil.Uint64  `json:"gas"` // defaults to the block gas limit
+	Data  hexutil.Bytes   `json:"data"`
+}
+
+// RunBundle applies an ordered bundle of messages read from a json file on
+// top of the given storage and reports the result and state diff of each one.
+// The list of state diffs is written to the given path in the format of
+// geth's prestateTracer if set.
+func RunBundle(storageType string, path string, blockID string, bundlePath string, abortOnFailure bool, stateDiffPath string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	var bundle []bundleMessage
+	if err := readJSON(bundlePath, &bundle); err != nil {
+		log.Error("Unable to read bundle", "path", bundlePath, "err", err)
+		return
+	}
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	msgs, err := toMessages(bundle, sender, block.GasLimit)
+	if err != nil {
+		log.Error("Invalid bundle", "err", err)
+		return
+	}
+
+	log.Info("Simulating bundle", "messages", len(msgs), "abort on failure", abortOnFailure)
+	result, err := evm.SimulateBundle(config, block, storage, msgs, abortOnFailure, nil)
+	if err != nil {
+		log.Error("Unable to simulate bundle", "err", err)
+		return
+	}
+
+	for i, res := range result.Results {
+		log.Info("### Message", "index", i, "gas used", res.UsedGas, "logs", len(res.Logs), "return data", common.Bytes2Hex(res.ReturnData), "err", res.Err)
+		for address, post := range res.StateDiff.Post {
+			printAccountDiff(address, res.StateDiff.Pre[address], post)
+		}
+	}
+	if result.Aborted {
+		log.Warn("Bundle aborted", "executed", len(result.Results), "total", len(msgs))
+	}
+	if stateDiffPath != "" {
+		diffs := make([]*evm.StateDiff, len(result.Results))
+		for i, res := range result.Results {
+			diffs[i] = res.StateDiff
+		}
+		if err := writeJSON(stateDiffPath, diffs); err != nil {
+			log.Error("Unable to write state diffs", "path", stateDiffPath, "err", err)
+			return
+		}
+		log.Info("Wrote state diffs", "path", stateDiffPath, "messages", len(diffs))
+	}
+}
+
+// toMessages converts the messages read from json, the sender and the gas
+// limit are used as defaults. The nonces are taken from the state.
+func toMessages(bundle []bundleMessage, sender common.Address, gasLimit uint64) ([]*evm.Message, error) {
+	msgs := make([]*evm.Message, len(bundle))
+	for i, args := range bundle {
+		msg := &evm.Message{
+			From:              sender,
+			To:                args.To,
+			Value:             new(uint256.Int),
+			GasLimit:          uint64(args.Gas),
+			GasPrice:          new(uint256.Int),
+			GasFeeCap:         new(uint256.Int),
+			GasTipCap:         new(uint256.Int),
+			Data:              args.Data,
+			SkipAccountChecks: true,
+		}
+		if args.From != nil {
+			msg.From = *args.From
+		}
+		if args.Value != nil {
+			value, overflow := uint256.FromBig(args.Value.ToInt())
+			if overflow {
+				return nil, fmt.Errorf("invalid value %v of message %d", args.Value, i)
+			}
+			msg.Value = value
+		}
+		if msg.GasLimit == 0 {
+			msg.GasLimit = gasLimit
+		}
+		msgs[i] = msg
+	}
+	return msgs, nil
+}
+
+func printAccountDiff(address common.Address, pre, post *evm.AccountState) {
+	if pre == nil {
+		pre = new(evm.AccountState)
+	}
+	ctx := []interface{}{"address", address}
+	if post.Balance != nil {
+		ctx = append(ctx, "balance", fmt.Sprintf("%v -> %v", pre.Balance, post.Balance))
+	}
+	if post.Nonce != nil {
+		var prev uint64
+		if pre.Nonce != nil {
+			prev = *pre.Nonce
+		}
+		ctx = append(ctx, "nonce", fmt.Sprintf("%d -> %d", prev, *post.Nonce))
+	}
+	if post.Code != nil {
+		ctx = append(ctx, "code", fmt.Sprintf("%d -> %d bytes", len(pre.Code), len(post.Code)))
+	}
+	for key, value := range post.Storage {
+		ctx = append(ctx, key.Hex(), fmt.Sprintf("%v -> %v", pre.Storage[key].Hex(), value.Hex()))
+	}
+	log.Info("State diff", ctx...)
+}
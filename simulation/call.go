@@ -0,0 +1,92 @@
+package simulation
+
+import (
+	"encoding/json"
+	"goevm/evm"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunCall executes an eth_call style message against the contract on top of
+// the given storage with optional state and block overrides. The overrides are
+// read from json files in the same format as geth's eth_call. The state diff
+// is written to the given path in the format of geth's prestateTracer if set.
+func RunCall(storageType string, path string, blockID string, contractAddress string, calldata string, stateOverridesPath string, blockOverridesPath string, stateDiffPath string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	// Initialise the contract address
+	contract := common.HexToAddress(contractAddress)
+
+	var (
+		stateOverrides evm.StateOverride
+		blockOverrides *evm.BlockOverrides
+	)
+	if stateOverridesPath != "" {
+		if err := readJSON(stateOverridesPath, &stateOverrides); err != nil {
+			log.Error("Unable to read state overrides", "path", stateOverridesPath, "err", err)
+			return
+		}
+	}
+	if blockOverridesPath != "" {
+		blockOverrides = new(evm.BlockOverrides)
+		if err := readJSON(blockOverridesPath, blockOverrides); err != nil {
+			log.Error("Unable to read block overrides", "path", blockOverridesPath, "err", err)
+			return
+		}
+	}
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	tracer := evm.NewTracer()
+	msg := &evm.Message{
+		From: sender,
+		To:   &contract,
+		Data: common.FromHex(calldata),
+	}
+
+	log.Info("Executing call", "contract", contract, "overrides", len(stateOverrides))
+	result, diff, err := evm.CallWithStateDiff(config, block, storage, msg, stateOverrides, blockOverrides, tracer)
+	if err != nil {
+		log.Error("Unable to execute call", "err", err)
+		return
+	}
+
+	log.Info("Call executed", "gas used", result.UsedGas, "return data", common.Bytes2Hex(result.ReturnData), "err", result.Err)
+	if reason := result.Revert(); len(reason) > 0 {
+		log.Info("Call reverted", "reason", common.Bytes2Hex(reason))
+	}
+	for address, post := range diff.Post {
+		printAccountDiff(address, diff.Pre[address], post)
+	}
+	if stateDiffPath != "" {
+		if err := writeJSON(stateDiffPath, diff); err != nil {
+			log.Error("Unable to write state diff", "path", stateDiffPath, "err", err)
+			return
+		}
+		log.Info("Wrote state diff", "path", stateDiffPath, "accounts", len(diff.Pre)+len(diff.Post))
+	}
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
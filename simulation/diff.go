@@ -0,0 +1,95 @@
+package simulation
+
+import (
+	"fmt"
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/uint256"
+)
+
+// RunDiff executes a message through goevm and geth's core/vm and reports the
+// first step at which they diverge. With the simple storage, the given code is
+// deployed at the contract address. With the remote storage, the code of the
+// contract is read from the datadir.
+func RunDiff(storageType string, path string, blockID string, contractAddress string, code string, calldata string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	// Initialise the contract address
+	contract := common.HexToAddress(contractAddress)
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	if simple, ok := storage.(*evm.SimpleStorage); ok {
+		simple.CreateAccount(contract)
+		simple.SetCode(contract, common.FromHex(code))
+	}
+
+	var nonce uint64
+	n, err := storage.GetNonce(sender)
+	if err != nil {
+		log.Error("Unable to read sender nonce", "err", err)
+		return
+	}
+	if n != nil {
+		nonce = *n
+	}
+	gasPrice := new(uint256.Int)
+	if block.BaseFee != nil {
+		gasPrice.Set(block.BaseFee)
+	}
+	msg := &evm.Message{
+		From:      sender,
+		To:        &contract,
+		Nonce:     nonce,
+		Value:     new(uint256.Int),
+		GasLimit:  1_000_000,
+		GasPrice:  gasPrice,
+		GasFeeCap: gasPrice,
+		GasTipCap: new(uint256.Int),
+		Data:      common.FromHex(calldata),
+	}
+
+	log.Info("Running differential execution", "contract", contract, "storage", storageType)
+	result, err := evm.DiffExecution(config, block, storage, msg)
+	if err != nil {
+		log.Error("Unable to run differential execution", "err", err)
+		return
+	}
+
+	log.Info("goevm execution", "steps", len(result.LocalSteps), "gas used", result.Local.UsedGas, "err", result.Local.Err)
+	log.Info("geth execution", "steps", len(result.RemoteSteps), "gas used", result.Remote.UsedGas, "err", result.Remote.Err)
+
+	divergence := result.Divergence
+	if divergence == nil {
+		log.Info("Executions match step by step")
+		return
+	}
+
+	log.Warn("Executions diverge", "step", divergence.Step, "field", divergence.Field)
+	for i, step := range divergence.Context {
+		printStep(fmt.Sprintf("### Step %d", divergence.Step-len(divergence.Context)+i), &step)
+	}
+	printStep("### goevm", divergence.Local)
+	printStep("### geth", divergence.Remote)
+}
+
+func printStep(prefix string, step *evm.StepLog) {
+	if step == nil {
+		log.Info(prefix + ": execution stopped")
+		return
+	}
+	stack := make([]string, len(step.Stack))
+	for i := range step.Stack {
+		stack[i] = step.Stack[i].Hex()
+	}
+	log.Info(prefix, "pc", step.Pc, "opcode", step.Op, "gas", step.Gas, "stack", stack, "memory", hexutil.Encode(step.Memory), "storage", step.Storage)
+}
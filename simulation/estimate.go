@@ -0,0 +1,47 @@
+package simulation
+
+import (
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunEstimate estimates the gas required by a message against the contract on
+// top of the given storage with optional state overrides
+func RunEstimate(storageType string, path string, blockID string, contractAddress string, calldata string, stateOverridesPath string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	// Initialise the contract address
+	contract := common.HexToAddress(contractAddress)
+
+	var stateOverrides evm.StateOverride
+	if stateOverridesPath != "" {
+		if err := readJSON(stateOverridesPath, &stateOverrides); err != nil {
+			log.Error("Unable to read state overrides", "path", stateOverridesPath, "err", err)
+			return
+		}
+	}
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	msg := &evm.Message{
+		From: sender,
+		To:   &contract,
+		Data: common.FromHex(calldata),
+	}
+
+	log.Info("Estimating gas", "contract", contract, "overrides", len(stateOverrides))
+	gas, err := evm.EstimateGas(config, block, storage, msg, stateOverrides)
+	if err != nil {
+		log.Error("Unable to estimate gas", "err", err)
+		return
+	}
+	log.Info("Gas estimated", "gas", gas)
+}
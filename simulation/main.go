@@ -9,7 +9,10 @@ import (
 	"github.com/holiman/uint256"
 )
 
-func RunSimpleSimulation() {
+// RunSimpleSimulation runs the simulation on top of the in-memory storage. The
+// storage is seeded from the state file (genesis alloc or geth dump) if given,
+// and the final state is written to the dump path in the given format.
+func RunSimpleSimulation(statePath string, dumpPath string, dumpFormat string) {
 	// Create a temporary address for simulation
 	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
 
@@ -20,10 +23,76 @@ func RunSimpleSimulation() {
 	storage := evm.NewSimpleStorage(tracer)
 	defer storage.Close()
 
-	// Create a new account and set some balance
-	storage.CreateAccount(sender)
-	storage.SetBalance(sender, uint256.NewInt(10000))
+	if statePath != "" {
+		if err := storage.ImportState(statePath); err != nil {
+			log.Error("Unable to import state", "err", err)
+			return
+		}
+	}
+
+	// Create a new account and set some balance, unless it's part of the state
+	if err := fundAccount(storage, sender, uint256.NewInt(10000)); err != nil {
+		log.Error("Unable to fund sender", "err", err)
+		return
+	}
+
+	code := simpleProgram()
+
+	// Initialise EVM instance
+	opts := evm.NewExecutionOpts(common.Address{}, sender, 1, []byte{}, code, 42000)
+	evm := evm.NewEVM(evm.BlockContext{}, storage, opts, tracer)
+
+	log.Info("Initialized new evm instance, starting simple simulation", "len", len(code))
+
+	evm.Run()
+
+	if dumpPath != "" {
+		if err := storage.ExportState(dumpPath, dumpFormat); err != nil {
+			log.Error("Unable to export state", "err", err)
+			return
+		}
+	}
+
+	log.Info("Done execution, exiting")
+}
+
+// RunDiskSimulation runs the simple simulation on top of the disk storage at
+// the given path, so that the written state survives across runs
+func RunDiskSimulation(path string) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	// Create a new tracer
+	tracer := evm.NewTracer()
+
+	// Create a new storage using tracer, the state is committed when closed
+	storage, err := evm.NewDiskStorage(path, "", tracer)
+	if err != nil {
+		log.Error("Unable to open disk storage", "err", err)
+		return
+	}
+	defer storage.Close()
 
+	// Create a new account and set some balance, unless it's already persisted
+	if err := fundAccount(storage, sender, uint256.NewInt(10000)); err != nil {
+		log.Error("Unable to fund sender", "err", err)
+		return
+	}
+
+	// Initialise EVM instance
+	code := simpleProgram()
+	opts := evm.NewExecutionOpts(common.Address{}, sender, 1, []byte{}, code, 42000)
+	evm := evm.NewEVM(evm.BlockContext{}, storage, opts, tracer)
+
+	log.Info("Initialized new evm instance, starting disk simulation", "len", len(code))
+
+	evm.Run()
+
+	log.Info("Done execution, exiting")
+}
+
+// simpleProgram returns the code executed by the simple simulation
+func simpleProgram() []byte {
 	// Arithmetic/Comparision/Logical operations
 	var opcodes []evm.OpCode = []evm.OpCode{
 		evm.PUSH1, 0x5, // Pushes 5 to stack [0x5]
@@ -62,20 +131,10 @@ func RunSimpleSimulation() {
 	}
 
 	opcodes = append(opcodes, withWrite...)
-	code := *(*[]byte)(unsafe.Pointer(&opcodes))
-
-	// Initialise EVM instance
-	opts := evm.NewExecutionOpts(common.Address{}, sender, 1, []byte{}, code, 42000)
-	evm := evm.NewEVM(storage, opts, tracer)
-
-	log.Info("Initialized new evm instance, starting simple simulation", "len", len(code))
-
-	evm.Run()
-
-	log.Info("Done execution, exiting")
+	return *(*[]byte)(unsafe.Pointer(&opcodes))
 }
 
-func RunRemoteSimulation(path string, contractAddress string) {
+func RunRemoteSimulation(path string, blockID string, contractAddress string) {
 	// Create a temporary address for simulation
 	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
 
@@ -85,8 +144,21 @@ func RunRemoteSimulation(path string, contractAddress string) {
 	// Create a new tracer
 	tracer := evm.NewTracer()
 
+	block, err := parseBlock(blockID)
+	if err != nil {
+		log.Error("Invalid block", "block", blockID, "err", err)
+		return
+	}
+
 	// Create a new storage using tracer
-	storage := evm.NewRemoteStorage(path, tracer)
+	remote, err := evm.NewRemoteStorageAt(path, block, tracer)
+	if err != nil {
+		log.Error("Unable to open remote storage", "err", err)
+		return
+	}
+
+	// Keep the writes in an overlay, the datadir is never written
+	storage := evm.NewOverlayStorage(remote, tracer)
 	defer storage.Close()
 
 	// Arithmetic/Comparision/Logical operations
@@ -120,16 +192,32 @@ func RunRemoteSimulation(path string, contractAddress string) {
 		evm.PUSH1, 0x0, // Pushes 0 to stack [0x0] (key) (val1 in our test contract)
 		evm.SLOAD,      // Load value from storage at key
 		evm.PUSH1, 0x1, // Pushes 2 to stack [0x1] (key) (val2 in our test contract)
-		evm.SLOAD, // Load value from storage at key
+		evm.SLOAD,       // Load value from storage at key
+		evm.PUSH1, 0x20, // Pushes 32 to stack [0x20] (to load value from memory)
+		evm.MLOAD,      // Load value from memory at offset 32 (value = 0x2)
+		evm.PUSH1, 0x1, // Pushes 1 to stack (key)
+		evm.SSTORE,     // Store value at key in the overlay (key = 0x1, value = 0x2)
+		evm.PUSH1, 0x1, // Pushes 1 to stack [0x1] (key)
+		evm.SLOAD, // Load the written value from the overlay
 		evm.STOP,  // STOP
 	}...)
 
 	// Initialise EVM instance
 	code := *(*[]byte)(unsafe.Pointer(&opcodes))
 	opts := evm.NewExecutionOpts(contract, sender, 1, []byte{}, code, 42000)
-	evm := evm.NewEVM(storage, opts, tracer)
+	evm := evm.NewEVM(evm.BlockContext{}, storage, opts, tracer)
 
 	log.Info("Initialized new evm instance, starting remote simulation", "len", len(code))
 	evm.Run()
-	log.Info("Done execution, exiting")
+
+	diff, err := storage.Diff()
+	if err != nil {
+		log.Error("Unable to compute the diff", "err", err)
+		return
+	}
+	for address, post := range diff.Post {
+		printAccountDiff(address, diff.Pre[address], post)
+	}
+	storage.Discard()
+	log.Info("Done execution, discarded the overlay, exiting")
 }
@@ -0,0 +1,53 @@
+package simulation
+
+import (
+	"goevm/evm"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunParallel applies each message read from a json file independently on
+// top of the given storage using a pool of workers, and reports the result
+// and state diff of each one
+func RunParallel(storageType string, path string, blockID string, messagesPath string, workers int) {
+	// Create a temporary address for simulation
+	sender := common.HexToAddress("0x350fbDe850998AAC40f0b9364b4ACeA665a3d08c")
+
+	var messages []bundleMessage
+	if err := readJSON(messagesPath, &messages); err != nil {
+		log.Error("Unable to read messages", "path", messagesPath, "err", err)
+		return
+	}
+
+	storage, config, block, err := openStorage(storageType, path, blockID, sender)
+	if err != nil {
+		log.Error("Unable to open storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	msgs, err := toMessages(messages, sender, block.GasLimit)
+	if err != nil {
+		log.Error("Invalid messages", "err", err)
+		return
+	}
+
+	log.Info("Simulating messages in parallel", "messages", len(msgs), "workers", workers)
+	start := time.Now()
+	results := evm.SimulateParallel(config, block, storage, msgs, workers)
+	elapsed := time.Since(start)
+
+	for i, res := range results {
+		if res.Err != nil {
+			log.Error("### Message", "index", i, "err", res.Err)
+			continue
+		}
+		log.Info("### Message", "index", i, "gas used", res.UsedGas, "logs", len(res.Logs), "return data", common.Bytes2Hex(res.ReturnData), "err", res.ExecutionResult.Err)
+		for address, post := range res.StateDiff.Post {
+			printAccountDiff(address, res.StateDiff.Pre[address], post)
+		}
+	}
+	log.Info("Done parallel simulation", "messages", len(msgs), "elapsed", elapsed)
+}
@@ -0,0 +1,54 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunProof generates the merkle proofs of the account and the given storage
+// slots from the datadir, verifies them against the state root of the block
+// and prints them as json (in the format of eth_getProof)
+func RunProof(path string, blockID string, address string, keys []string) {
+	block, err := parseBlock(blockID)
+	if err != nil {
+		log.Error("Invalid block", "block", blockID, "err", err)
+		return
+	}
+
+	storage, err := evm.NewRemoteStorageAt(path, block, nil)
+	if err != nil {
+		log.Error("Unable to open remote storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	slots := make([]common.Hash, 0, len(keys))
+	for _, key := range keys {
+		slots = append(slots, common.HexToHash(key))
+	}
+
+	log.Info("Generating proof", "address", address, "slots", len(slots))
+	result, err := storage.GetProof(common.HexToAddress(address), slots)
+	if err != nil {
+		log.Error("Unable to generate proof", "err", err)
+		return
+	}
+
+	root, _ := storage.StateRoot()
+	if err := evm.VerifyProof(root, result); err != nil {
+		log.Error("Generated proof is invalid", "root", root, "err", err)
+		return
+	}
+	log.Info("Proof verified", "root", root, "account nodes", len(result.AccountProof))
+
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Error("Unable to encode proof", "err", err)
+		return
+	}
+	fmt.Println(string(out))
+}
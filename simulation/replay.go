@@ -0,0 +1,42 @@
+package simulation
+
+import (
+	"goevm/evm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func RunReplay(path string, txHash string) {
+	// Create a new tracer
+	tracer := evm.NewTracer()
+
+	// The storage isn't traced, so that only the target transaction is
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		log.Error("Unable to open remote storage", "path", path, "err", err)
+		return
+	}
+	defer storage.Close()
+
+	hash := common.HexToHash(txHash)
+	log.Info("Replaying transaction", "hash", hash)
+
+	result, err := evm.ReplayTransaction(storage, hash, tracer)
+	if err != nil {
+		log.Error("Unable to replay transaction", "hash", hash, "err", err)
+		return
+	}
+
+	log.Info("Replayed transaction", "status", result.Local.Status, "gas used", result.Local.GasUsed, "logs", len(result.Local.Logs), "err", result.Result.Err)
+	if reason := result.Result.Revert(); len(reason) > 0 {
+		log.Info("Transaction reverted", "reason", common.Bytes2Hex(reason))
+	}
+	if len(result.Diffs) == 0 {
+		log.Info("Replay matches the stored receipt")
+		return
+	}
+	for _, diff := range result.Diffs {
+		log.Warn("Replay differs from the stored receipt", "diff", diff)
+	}
+}
@@ -0,0 +1,130 @@
+package simulation
+
+import (
+	"fmt"
+	"goevm/evm"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+)
+
+// openStorage opens the given type of storage along with the chain config
+// and block context to execute on top of it. The simple storage is seeded
+// with a funded sender account, as is the disk storage if the sender doesn't
+// exist yet. The remote and rpc ones use the config and the header of the
+// given block (latest if empty) from the datadir or the endpoint, in which
+// case the path is the url of the endpoint.
+func openStorage(storageType string, path string, blockID string, sender common.Address) (evm.Storage, *params.ChainConfig, evm.BlockContext, error) {
+	switch storageType {
+	case "simple":
+		storage := evm.NewSimpleStorage(nil)
+		if err := fundAccount(storage, sender, uint256.NewInt(params.Ether)); err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+
+		block := evm.BlockContext{
+			Number:   1,
+			Time:     1,
+			GasLimit: 30_000_000,
+			BaseFee:  uint256.NewInt(params.InitialBaseFee),
+		}
+		return storage, params.MergedTestChainConfig, block, nil
+	case "remote":
+		block, err := parseBlock(blockID)
+		if err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+		storage, err := evm.NewRemoteStorageAt(path, block, nil)
+		if err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+		config, err := storage.ChainConfig()
+		if err != nil {
+			storage.Close()
+			return nil, nil, evm.BlockContext{}, err
+		}
+		return storage, config, evm.NewBlockContext(storage.Header(), storage.GetHash), nil
+	case "disk":
+		storage, err := evm.NewDiskStorage(path, "", nil)
+		if err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+		if err := fundAccount(storage, sender, uint256.NewInt(params.Ether)); err != nil {
+			storage.Close()
+			return nil, nil, evm.BlockContext{}, err
+		}
+
+		block := evm.BlockContext{
+			Number:   1,
+			Time:     1,
+			GasLimit: 30_000_000,
+			BaseFee:  uint256.NewInt(params.InitialBaseFee),
+		}
+		return storage, params.MergedTestChainConfig, block, nil
+	case "rpc":
+		block, err := parseBlock(blockID)
+		if err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+		storage, err := evm.NewRPCStorage(path, block, rpcCacheDir(path), nil)
+		if err != nil {
+			return nil, nil, evm.BlockContext{}, err
+		}
+		config, err := storage.ChainConfig()
+		if err != nil {
+			storage.Close()
+			return nil, nil, evm.BlockContext{}, err
+		}
+		return storage, config, evm.NewBlockContext(storage.Header(), storage.GetHash), nil
+	default:
+		return nil, nil, evm.BlockContext{}, fmt.Errorf("invalid storage type %s", storageType)
+	}
+}
+
+// fundAccount creates the account with the given balance, unless it
+// already exists
+func fundAccount(storage evm.Storage, address common.Address, balance *uint256.Int) error {
+	current, err := storage.GetBalance(address)
+	if err != nil || current != nil {
+		return err
+	}
+	if err := storage.CreateAccount(address); err != nil {
+		return err
+	}
+	return storage.SetBalance(address, balance)
+}
+
+// parseBlock parses a block number (decimal or hex) or hash, the latest block
+// is used if empty
+func parseBlock(blockID string) (rpc.BlockNumberOrHash, error) {
+	if blockID == "" || blockID == "latest" {
+		return rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil
+	}
+	if len(blockID) == 2+2*common.HashLength && strings.HasPrefix(blockID, "0x") {
+		return rpc.BlockNumberOrHashWithHash(common.HexToHash(blockID), true), nil
+	}
+	number, err := strconv.ParseUint(blockID, 0, 63)
+	if err != nil {
+		return rpc.BlockNumberOrHash{}, fmt.Errorf("invalid block number or hash %q", blockID)
+	}
+	return rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number)), nil
+}
+
+// rpcCacheDir returns the directory in the user's cache where the responses
+// of the endpoint are persisted, empty if there's no cache directory
+func rpcCacheDir(url string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		log.Warn("No user cache directory, rpc responses won't be persisted", "err", err)
+		return ""
+	}
+	return filepath.Join(dir, "goevm", "rpc", crypto.Keccak256Hash([]byte(url)).Hex()[2:18])
+}
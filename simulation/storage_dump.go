@@ -0,0 +1,144 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"goevm/evm"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// storageWriter writes the slots of a storage dump in some format
+type storageWriter interface {
+	write(evm.StorageSlot) error
+	flush() error
+}
+
+// jsonStorageWriter writes the slots as a json list, one slot at a time so
+// that large contracts aren't kept in memory
+type jsonStorageWriter struct {
+	out   io.Writer
+	count int
+}
+
+func (w *jsonStorageWriter) write(slot evm.StorageSlot) error {
+	data, err := json.Marshal(slot)
+	if err != nil {
+		return err
+	}
+	separator := ",\n  "
+	if w.count == 0 {
+		separator = "[\n  "
+	}
+	w.count++
+	_, err = fmt.Fprintf(w.out, "%s%s", separator, data)
+	return err
+}
+
+func (w *jsonStorageWriter) flush() error {
+	if w.count == 0 {
+		_, err := fmt.Fprintln(w.out, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(w.out, "\n]")
+	return err
+}
+
+// csvStorageWriter writes the slots as csv rows with a header, the key is
+// left empty if its preimage isn't known
+type csvStorageWriter struct {
+	out    *csv.Writer
+	header bool
+}
+
+// writeHeader writes the header before the first row
+func (w *csvStorageWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.out.Write([]string{"hash", "key", "value"})
+}
+
+func (w *csvStorageWriter) write(slot evm.StorageSlot) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	var key string
+	if slot.Key != nil {
+		key = slot.Key.Hex()
+	}
+	return w.out.Write([]string{slot.Hash.Hex(), key, slot.Value.Hex()})
+}
+
+func (w *csvStorageWriter) flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.out.Flush()
+	return w.out.Error()
+}
+
+// RunStorageDump iterates the whole storage trie of the contract at the given
+// block of the datadir and writes every slot along with its original key if
+// the preimage is known, either as json or csv. The dump is printed unless an
+// output path is given.
+func RunStorageDump(path string, blockID string, address string, format string, outPath string) {
+	if format != "json" && format != "csv" {
+		log.Error("Unknown dump format", "format", format)
+		return
+	}
+	block, err := parseBlock(blockID)
+	if err != nil {
+		log.Error("Invalid block", "block", blockID, "err", err)
+		return
+	}
+	storage, err := evm.NewRemoteStorageAt(path, block, nil)
+	if err != nil {
+		log.Error("Unable to open remote storage", "err", err)
+		return
+	}
+	defer storage.Close()
+
+	// The output is only created once the storage is open
+	out := io.Writer(os.Stdout)
+	if outPath != "" {
+		file, err := os.Create(outPath)
+		if err != nil {
+			log.Error("Unable to create output file", "path", outPath, "err", err)
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+	var writer storageWriter = &jsonStorageWriter{out: out}
+	if format == "csv" {
+		writer = &csvStorageWriter{out: csv.NewWriter(out)}
+	}
+
+	var slots, preimages int
+	contract := common.HexToAddress(address)
+	err = storage.IterateStorage(contract, func(slot evm.StorageSlot) error {
+		slots++
+		if slot.Key != nil {
+			preimages++
+		}
+		if slots%100_000 == 0 {
+			log.Info("Dumping storage", "slots", slots)
+		}
+		return writer.write(slot)
+	})
+	// The slots written so far are terminated, so that the dump stays valid
+	if flushErr := writer.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Error("Unable to dump storage", "address", contract, "slots", slots, "err", err)
+		return
+	}
+	log.Info("Dumped storage", "address", contract, "number", storage.Header().Number, "slots", slots, "preimages", preimages)
+}
@@ -0,0 +1,88 @@
+package simulation
+
+import (
+	"fmt"
+	"goevm/evm"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RunLayout reads the state variables of the contract using its solc storage
+// layout and prints them by name. The simple storage is seeded from the state
+// file if given. Mapping entries are read for the given keys, each formatted
+// as name=key or name=key1,key2 for nested mappings.
+func RunLayout(storageType string, path string, blockID string, contractAddress string, layoutPath string, statePath string, keys []string, maxElements uint64) {
+	layout := new(evm.StorageLayout)
+	if err := readJSON(layoutPath, layout); err != nil {
+		log.Error("Unable to read storage layout", "err", err)
+		return
+	}
+
+	var storage evm.Storage
+	switch storageType {
+	case "simple":
+		simple := evm.NewSimpleStorage(nil)
+		if statePath != "" {
+			if err := simple.ImportState(statePath); err != nil {
+				log.Error("Unable to import state", "err", err)
+				return
+			}
+		}
+		storage = simple
+	case "disk":
+		// Opened directly, as no sender has to be funded for reading
+		disk, err := evm.NewDiskStorage(path, "", nil)
+		if err != nil {
+			log.Error("Unable to open storage", "err", err)
+			return
+		}
+		storage = disk
+	default:
+		opened, _, _, err := openStorage(storageType, path, blockID, common.Address{})
+		if err != nil {
+			log.Error("Unable to open storage", "err", err)
+			return
+		}
+		storage = opened
+	}
+	defer storage.Close()
+
+	decoder := evm.NewStorageDecoder(storage, common.HexToAddress(contractAddress), layout, maxElements)
+	for _, key := range keys {
+		name, values, ok := strings.Cut(key, "=")
+		if !ok || name == "" || values == "" {
+			log.Error("Invalid mapping key, expected name=key", "key", key)
+			return
+		}
+		decoder.AddKeys(name, strings.Split(values, ",")...)
+	}
+	values, err := decoder.Decode()
+	if err != nil {
+		log.Error("Unable to decode storage", "address", contractAddress, "err", err)
+		return
+	}
+	for _, value := range values {
+		printStorageValue(value, 0)
+	}
+}
+
+// printStorageValue prints the value and its members, indented by depth
+func printStorageValue(value *evm.StorageValue, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch {
+	case value.Value != "":
+		fmt.Printf("%s%s (%s) = %s\n", indent, value.Name, value.Type, value.Value)
+	case value.Length > 0 || strings.HasSuffix(value.Type, "[]"):
+		fmt.Printf("%s%s (%s) length=%d\n", indent, value.Name, value.Type, value.Length)
+	default:
+		fmt.Printf("%s%s (%s)\n", indent, value.Name, value.Type)
+	}
+	for _, member := range value.Members {
+		printStorageValue(member, depth+1)
+	}
+	if value.Truncated {
+		fmt.Printf("%s  ... %d more\n", indent, value.Length-uint64(len(value.Members)))
+	}
+}
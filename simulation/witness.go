@@ -0,0 +1,81 @@
+package simulation
+
+import (
+	"goevm/evm"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// RunWitness replays a historical transaction from the datadir while
+// recording the witness of the state it reads, reports the size of the
+// witness and re-executes the transaction from the witness alone. The
+// witness is written to the output path (rlp encoded) if given.
+func RunWitness(path string, txHash string, outPath string) {
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		log.Error("Unable to open remote storage", "path", path, "err", err)
+		return
+	}
+	defer storage.Close()
+
+	config, err := storage.ChainConfig()
+	if err != nil {
+		log.Error("Unable to read chain config", "err", err)
+		return
+	}
+
+	hash := common.HexToHash(txHash)
+	log.Info("Recording witness", "hash", hash)
+	replay, err := evm.ReplayTransactionWithWitness(storage, hash, nil)
+	if err != nil {
+		log.Error("Unable to replay transaction", "hash", hash, "err", err)
+		return
+	}
+
+	encoded, err := rlp.EncodeToBytes(replay.Witness)
+	if err != nil {
+		log.Error("Unable to encode witness", "err", err)
+		return
+	}
+	var stateSize, codeSize int
+	for node := range replay.Witness.State {
+		stateSize += len(node)
+	}
+	for code := range replay.Witness.Codes {
+		codeSize += len(code)
+	}
+	log.Info("Recorded witness", "size", common.StorageSize(len(encoded)), "nodes", len(replay.Witness.State), "node size", common.StorageSize(stateSize),
+		"codes", len(replay.Witness.Codes), "code size", common.StorageSize(codeSize), "headers", len(replay.Witness.Headers))
+
+	if outPath != "" {
+		if err := os.WriteFile(outPath, encoded, 0644); err != nil {
+			log.Error("Unable to write witness", "path", outPath, "err", err)
+			return
+		}
+		log.Info("Wrote witness", "path", outPath)
+	}
+
+	// Decode the witness again, so that nothing but the encoded form is used
+	witness := new(stateless.Witness)
+	if err := rlp.DecodeBytes(encoded, witness); err != nil {
+		log.Error("Unable to decode witness", "err", err)
+		return
+	}
+	result, err := evm.ExecuteWitness(config, witness, hash, replay.Local, nil)
+	if err != nil {
+		log.Error("Unable to execute transaction from witness", "err", err)
+		return
+	}
+	log.Info("Executed transaction from witness", "status", result.Local.Status, "gas used", result.Local.GasUsed, "logs", len(result.Local.Logs))
+	if len(result.Diffs) == 0 {
+		log.Info("Witness execution matches the replay")
+		return
+	}
+	for _, diff := range result.Diffs {
+		log.Warn("Witness execution differs from the replay", "diff", diff)
+	}
+}
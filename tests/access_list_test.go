@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestCreateAccessList(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x3000")
+		other    = common.HexToAddress("0x42")
+		slot     = common.BigToHash(common.Big1)
+	)
+	storage := newTestStorage()
+	storage.CreateAccount(contract)
+	storage.SetCode(contract, toCode([]evm.OpCode{
+		evm.PUSH1, 0x1,
+		evm.SLOAD,
+		evm.PUSH1, 0x42,
+		evm.BALANCE,
+		evm.PUSH1, 0x1, // precompile
+		evm.BALANCE,
+		evm.STOP,
+	}))
+
+	msg := &evm.Message{From: testAddress, To: &contract}
+	result, err := evm.CreateAccessList(params.MergedTestChainConfig, evm.BlockContext{GasLimit: 1_000_000}, storage, msg)
+	if err != nil {
+		t.Fatalf("Failed to create access list: %v", err)
+	}
+	if result.Err != nil {
+		t.Fatalf("Execution failed: %v", result.Err)
+	}
+
+	list := result.AccessList
+	if len(list) != 2 {
+		t.Fatalf("Invalid access list length, expected: %d, got: %d", 2, len(list))
+	}
+	if list[0].Address != other || len(list[0].StorageKeys) != 0 {
+		t.Fatalf("Invalid access list entry, expected: %v with no keys, got: %v with %v", other, list[0].Address, list[0].StorageKeys)
+	}
+	if list[1].Address != contract || len(list[1].StorageKeys) != 1 || list[1].StorageKeys[0] != slot {
+		t.Fatalf("Invalid access list entry, expected: %v with %v, got: %v with %v", contract, slot, list[1].Address, list[1].StorageKeys)
+	}
+
+	// Only the intrinsic cost of the list differs
+	expected := result.GasUsedWithout + 2*params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas
+	if result.GasUsed != expected {
+		t.Fatalf("Invalid gas used, expected: %d, got: %d", expected, result.GasUsed)
+	}
+}
+
+func TestCreateAccessListCoinbase(t *testing.T) {
+	contract := common.HexToAddress("0x3000")
+	storage := newTestStorage()
+	storage.CreateAccount(contract)
+	storage.SetCode(contract, toCode([]evm.OpCode{evm.PUSH1, 0x1, evm.SLOAD, evm.STOP}))
+
+	// Before Shanghai the coinbase isn't warm, but crediting it with the tip
+	// isn't an access of the message
+	block := evm.BlockContext{GasLimit: 1_000_000, Coinbase: coinbase}
+	msg := &evm.Message{From: testAddress, To: &contract, GasPrice: uint256.NewInt(1)}
+	result, err := evm.CreateAccessList(params.AllEthashProtocolChanges, block, storage, msg)
+	if err != nil {
+		t.Fatalf("Failed to create access list: %v", err)
+	}
+	if len(result.AccessList) != 1 || result.AccessList[0].Address != contract {
+		t.Fatalf("Invalid access list, expected: %v only, got: %v", contract, result.AccessList)
+	}
+}
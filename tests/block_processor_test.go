@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"errors"
+	"goevm/evm"
+	"math/big"
+	"testing"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+	coinbase    = common.HexToAddress("0xc014ba5e")
+
+	// Stores the call value in slot 0 and emits a log with a single topic
+	storeAndLog = common.HexToAddress("0x1000")
+	// Reverts without any data
+	reverter = common.HexToAddress("0x2000")
+)
+
+func toCode(opcodes []evm.OpCode) []byte {
+	return *(*[]byte)(unsafe.Pointer(&opcodes))
+}
+
+func newTestStorage() *evm.SimpleStorage {
+	storage := evm.NewSimpleStorage(nil)
+	storage.CreateAccount(testAddress)
+	storage.SetBalance(testAddress, uint256.NewInt(params.Ether))
+
+	storage.CreateAccount(storeAndLog)
+	storage.SetCode(storeAndLog, toCode([]evm.OpCode{
+		evm.CALLVALUE,
+		evm.PUSH1, 0x0,
+		evm.SSTORE,
+		evm.PUSH1, 0x1, // topic
+		evm.PUSH1, 0x0, // size
+		evm.PUSH1, 0x0, // offset
+		evm.LOG1,
+		evm.STOP,
+	}))
+
+	storage.CreateAccount(reverter)
+	storage.SetCode(reverter, toCode([]evm.OpCode{
+		evm.PUSH1, 0x0,
+		evm.PUSH1, 0x0,
+		evm.REVERT,
+	}))
+	return storage
+}
+
+func newTestBlock(t *testing.T, gasLimit uint64, txs []types.TxData, withdrawals []*types.Withdrawal) *types.Block {
+	signer := types.LatestSigner(params.MergedTestChainConfig)
+	body := &types.Body{Withdrawals: withdrawals}
+	for _, data := range txs {
+		tx, err := types.SignNewTx(testKey, signer, data)
+		if err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		body.Transactions = append(body.Transactions, tx)
+	}
+	header := &types.Header{
+		Number:   big.NewInt(1),
+		GasLimit: gasLimit,
+		Time:     1,
+		Coinbase: coinbase,
+		BaseFee:  big.NewInt(params.InitialBaseFee),
+	}
+	return types.NewBlock(header, body, nil, trie.NewStackTrie(nil))
+}
+
+func dynamicFeeTx(nonce uint64, to common.Address, value int64) types.TxData {
+	return &types.DynamicFeeTx{
+		ChainID:   params.MergedTestChainConfig.ChainID,
+		Nonce:     nonce,
+		To:        &to,
+		Value:     big.NewInt(value),
+		Gas:       100000,
+		GasFeeCap: big.NewInt(2 * params.InitialBaseFee),
+		GasTipCap: big.NewInt(params.GWei),
+	}
+}
+
+func TestBlockProcessor(t *testing.T) {
+	storage := newTestStorage()
+	withdrawal := &types.Withdrawal{Index: 0, Validator: 1, Address: common.HexToAddress("0xdead"), Amount: 10}
+	block := newTestBlock(t, 1_000_000, []types.TxData{
+		dynamicFeeTx(0, storeAndLog, 5),
+		dynamicFeeTx(1, reverter, 0),
+	}, []*types.Withdrawal{withdrawal})
+
+	processor := evm.NewBlockProcessor(params.MergedTestChainConfig, storage, nil, nil)
+	result, err := processor.Process(block)
+	if err != nil {
+		t.Fatalf("Failed to process block: %v", err)
+	}
+	if len(result.Receipts) != 2 {
+		t.Fatalf("Invalid receipt count, expected: %d, got: %d", 2, len(result.Receipts))
+	}
+
+	first, second := result.Receipts[0], result.Receipts[1]
+	if first.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("Invalid status of first receipt, expected: %d, got: %d", types.ReceiptStatusSuccessful, first.Status)
+	}
+	// 21000 intrinsic + 2 (CALLVALUE) + 3 (PUSH1) + 100 (SSTORE) + 9 (3 x PUSH1) + 750 (LOG1)
+	if first.GasUsed != 21864 {
+		t.Fatalf("Invalid gas used by first tx, expected: %d, got: %d", 21864, first.GasUsed)
+	}
+	if len(first.Logs) != 1 || first.Logs[0].Address != storeAndLog || first.Logs[0].Topics[0] != common.BigToHash(big.NewInt(1)) {
+		t.Fatalf("Invalid logs in first receipt, got: %v", first.Logs)
+	}
+	if second.Status != types.ReceiptStatusFailed {
+		t.Fatalf("Invalid status of second receipt, expected: %d, got: %d", types.ReceiptStatusFailed, second.Status)
+	}
+	if second.CumulativeGasUsed != first.GasUsed+second.GasUsed || result.GasUsed != second.CumulativeGasUsed {
+		t.Fatalf("Invalid cumulative gas, expected: %d, got: %d", first.GasUsed+second.GasUsed, second.CumulativeGasUsed)
+	}
+
+	if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != common.BigToHash(big.NewInt(5)) {
+		t.Fatalf("Invalid stored value, expected: %v, got: %v", common.BigToHash(big.NewInt(5)), value)
+	}
+	if nonce, _ := storage.GetNonce(testAddress); *nonce != 2 {
+		t.Fatalf("Invalid sender nonce, expected: %d, got: %d", 2, *nonce)
+	}
+	expected := uint256.NewInt(10 * params.GWei)
+	if balance, _ := storage.GetBalance(withdrawal.Address); balance.Cmp(expected) != 0 {
+		t.Fatalf("Invalid withdrawal balance, expected: %v, got: %v", expected, balance)
+	}
+	tip := uint256.NewInt(result.GasUsed * params.GWei)
+	if balance, _ := storage.GetBalance(coinbase); balance.Cmp(tip) != 0 {
+		t.Fatalf("Invalid coinbase balance, expected: %v, got: %v", tip, balance)
+	}
+
+	// A block built with the same receipts and state must pass the validation
+	header := block.Header()
+	header.GasUsed = result.GasUsed
+	header.Root = result.Root
+	built := types.NewBlock(header, &types.Body{Transactions: block.Transactions(), Withdrawals: block.Withdrawals()}, result.Receipts, trie.NewStackTrie(nil))
+	if err := evm.ValidateState(built.Header(), result); err != nil {
+		t.Fatalf("Failed to validate block: %v", err)
+	}
+}
+
+func TestBlockProcessorGasLimit(t *testing.T) {
+	storage := newTestStorage()
+	block := newTestBlock(t, 120_000, []types.TxData{
+		dynamicFeeTx(0, storeAndLog, 0),
+		dynamicFeeTx(1, storeAndLog, 0),
+	}, nil)
+
+	processor := evm.NewBlockProcessor(params.MergedTestChainConfig, storage, nil, nil)
+	if _, err := processor.Process(block); !errors.Is(err, evm.ErrGasLimitReached) {
+		t.Fatalf("Invalid error, expected: %v, got: %v", evm.ErrGasLimitReached, err)
+	}
+}
+
+func TestLogMemoryOutOfGas(t *testing.T) {
+	var (
+		logger = common.HexToAddress("0x3000")
+		block  = evm.BlockContext{GasLimit: 30_000_000}
+	)
+	huge := []evm.OpCode{evm.PUSH8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	overflow := append([]evm.OpCode{evm.PUSH32}, make([]evm.OpCode, 32)...)
+	for i := 1; i < len(overflow); i++ {
+		overflow[i] = 0xff
+	}
+	tests := []struct {
+		size, offset []evm.OpCode
+		err          error
+	}{
+		{[]evm.OpCode{evm.PUSH1, 0x20}, []evm.OpCode{evm.PUSH1, 0x0}, nil},
+		{huge, []evm.OpCode{evm.PUSH1, 0x0}, evm.ErrOutOfGas},
+		{[]evm.OpCode{evm.PUSH1, 0x20}, huge, evm.ErrOutOfGas},
+		{overflow, []evm.OpCode{evm.PUSH1, 0x0}, evm.ErrOutOfGas},
+		{[]evm.OpCode{evm.PUSH1, 0x20}, overflow, evm.ErrOutOfGas},
+	}
+	for i, test := range tests {
+		// The memory isn't allocated for a range which can't be paid for
+		code := append(append(append([]evm.OpCode{}, test.size...), test.offset...), evm.LOG0, evm.STOP)
+		storage := newTestStorage()
+		storage.CreateAccount(logger)
+		storage.SetCode(logger, toCode(code))
+		result, err := evm.ApplyMessage(params.MergedTestChainConfig, block, storage, bundleMessage(logger, 0), nil)
+		if err != nil {
+			t.Fatalf("Failed to apply message %d: %v", i, err)
+		}
+		if !errors.Is(result.Err, test.err) {
+			t.Fatalf("Invalid error of message %d, expected: %v, got: %v", i, test.err, result.Err)
+		}
+		if test.err == nil && (len(result.Logs) != 1 || len(result.Logs[0].Data) != 32) {
+			t.Fatalf("Invalid logs of message %d, expected: %d with %d bytes, got: %v", i, 1, 32, result.Logs)
+		}
+	}
+}
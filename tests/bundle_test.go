@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func bundleMessage(to common.Address, value uint64) *evm.Message {
+	return &evm.Message{
+		From:              testAddress,
+		To:                &to,
+		Value:             uint256.NewInt(value),
+		GasLimit:          100_000,
+		GasPrice:          uint256.NewInt(0),
+		GasFeeCap:         uint256.NewInt(0),
+		GasTipCap:         uint256.NewInt(0),
+		SkipAccountChecks: true,
+	}
+}
+
+func TestSimulateBundle(t *testing.T) {
+	storage := newTestStorage()
+	block := evm.BlockContext{GasLimit: 30_000_000}
+	msgs := []*evm.Message{
+		bundleMessage(storeAndLog, 5),
+		bundleMessage(reverter, 0),
+		bundleMessage(storeAndLog, 7),
+	}
+
+	result, err := evm.SimulateBundle(params.MergedTestChainConfig, block, storage, msgs, false, nil)
+	if err != nil {
+		t.Fatalf("Failed to simulate bundle: %v", err)
+	}
+	if result.Aborted || len(result.Results) != 3 {
+		t.Fatalf("Invalid bundle result, expected: %d results, got: %d (aborted: %v)", 3, len(result.Results), result.Aborted)
+	}
+	if !result.Results[1].Failed() {
+		t.Fatalf("Expected message 1 to revert")
+	}
+
+	// The last message sees the slot written by the first one
+	diff := result.Results[2].StateDiff
+	slot := common.Hash{}
+	if pre := diff.Pre[storeAndLog].Storage[slot]; pre != common.BigToHash(big.NewInt(5)) {
+		t.Fatalf("Invalid pre state, expected: %v, got: %v", common.BigToHash(big.NewInt(5)), pre)
+	}
+	if post := diff.Post[storeAndLog].Storage[slot]; post != common.BigToHash(big.NewInt(7)) {
+		t.Fatalf("Invalid post state, expected: %v, got: %v", common.BigToHash(big.NewInt(7)), post)
+	}
+	if nonce := diff.Post[testAddress].Nonce; nonce == nil || *nonce != 3 {
+		t.Fatalf("Invalid sender nonce, expected: %d, got: %v", 3, nonce)
+	}
+	if logs := result.Results[2].Logs; len(logs) != 1 || logs[0].Index != 1 || logs[0].TxIndex != 2 {
+		t.Fatalf("Invalid logs, expected: index %d in message %d, got: %v", 1, 2, logs)
+	}
+
+	// Nothing is committed to the storage
+	if value, _ := storage.GetState(storeAndLog, slot); value != (common.Hash{}) {
+		t.Fatalf("Invalid slot in storage, expected: %v, got: %v", common.Hash{}, value)
+	}
+
+	// The bundle stops at the reverting message
+	result, err = evm.SimulateBundle(params.MergedTestChainConfig, block, storage, msgs, true, nil)
+	if err != nil {
+		t.Fatalf("Failed to simulate bundle: %v", err)
+	}
+	if !result.Aborted || len(result.Results) != 2 {
+		t.Fatalf("Invalid bundle result, expected: %d results, got: %d (aborted: %v)", 2, len(result.Results), result.Aborted)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestCallOverrides(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x3000")
+		slot1    = common.BigToHash(common.Big1)
+		slot2    = common.BigToHash(common.Big2)
+	)
+	storage := newTestStorage()
+	storage.CreateAccount(contract)
+	storage.SetState(contract, slot1, common.BigToHash(common.Big1))
+	storage.SetState(contract, slot2, common.BigToHash(common.Big2))
+
+	// Returns slot 1 + slot 2 + timestamp
+	code := hexutil.Bytes(toCode([]evm.OpCode{
+		evm.PUSH1, 0x1,
+		evm.SLOAD,
+		evm.PUSH1, 0x2,
+		evm.SLOAD,
+		evm.ADD,
+		evm.TIMESTAMP,
+		evm.ADD,
+		evm.PUSH1, 0x0,
+		evm.MSTORE,
+		evm.PUSH1, 0x20,
+		evm.PUSH1, 0x0,
+		evm.RETURN,
+	}))
+	time := hexutil.Uint64(0x10)
+
+	tests := []struct {
+		name      string
+		overrides evm.StateOverride
+		expected  uint64
+	}{
+		{
+			name:      "state diff",
+			overrides: evm.StateOverride{contract: {Code: &code, StateDiff: map[common.Hash]common.Hash{slot1: common.BigToHash(common.Big3)}}},
+			expected:  3 + 2 + 0x10,
+		},
+		{
+			name:      "state replacement",
+			overrides: evm.StateOverride{contract: {Code: &code, State: map[common.Hash]common.Hash{slot1: common.BigToHash(common.Big3)}}},
+			expected:  3 + 0x10,
+		},
+	}
+	for _, test := range tests {
+		msg := &evm.Message{From: testAddress, To: &contract}
+		result, err := evm.Call(params.MergedTestChainConfig, evm.BlockContext{GasLimit: 1_000_000}, storage, msg, test.overrides, &evm.BlockOverrides{Time: &time}, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to execute call: %v", test.name, err)
+		}
+		if result.Failed() {
+			t.Fatalf("%s: call failed: %v", test.name, result.Err)
+		}
+		if got := new(uint256.Int).SetBytes(result.ReturnData); got.Uint64() != test.expected {
+			t.Fatalf("%s: invalid return data, expected: %d, got: %d", test.name, test.expected, got.Uint64())
+		}
+	}
+
+	// The storage must not have been modified
+	if code, _ := storage.GetCode(contract); len(code) != 0 {
+		t.Fatalf("Invalid code in storage, expected: empty, got: %x", code)
+	}
+	if value, _ := storage.GetState(contract, slot1); value != common.BigToHash(common.Big1) {
+		t.Fatalf("Invalid slot in storage, expected: %v, got: %v", common.BigToHash(common.Big1), value)
+	}
+}
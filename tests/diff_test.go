@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func diffCode(t *testing.T, code []evm.OpCode) *evm.DiffResult {
+	contract := common.HexToAddress("0x3000")
+	storage := newTestStorage()
+	storage.CreateAccount(contract)
+	storage.SetCode(contract, toCode(code))
+
+	block := evm.BlockContext{
+		Number:   1,
+		Time:     1,
+		GasLimit: 30_000_000,
+		BaseFee:  uint256.NewInt(params.InitialBaseFee),
+	}
+	msg := &evm.Message{
+		From:      testAddress,
+		To:        &contract,
+		Value:     uint256.NewInt(0),
+		GasLimit:  100000,
+		GasPrice:  uint256.NewInt(params.InitialBaseFee),
+		GasFeeCap: uint256.NewInt(params.InitialBaseFee),
+		GasTipCap: uint256.NewInt(0),
+	}
+	result, err := evm.DiffExecution(params.MergedTestChainConfig, block, storage, msg)
+	if err != nil {
+		t.Fatalf("Failed to diff execution: %v", err)
+	}
+	return result
+}
+
+func TestDiffExecutionMatching(t *testing.T) {
+	result := diffCode(t, []evm.OpCode{
+		evm.PUSH1, 0x5,
+		evm.PUSH1, 0x6,
+		evm.ADD,
+		evm.PUSH1, 0x2,
+		evm.MUL,
+		evm.POP,
+		evm.STOP,
+	})
+	if result.Divergence != nil {
+		t.Fatalf("Unexpected divergence at step %d in %s", result.Divergence.Step, result.Divergence.Field)
+	}
+	if len(result.LocalSteps) != 7 {
+		t.Fatalf("Invalid step count, expected: %d, got: %d", 7, len(result.LocalSteps))
+	}
+	if result.Local.UsedGas != result.Remote.UsedGas {
+		t.Fatalf("Invalid gas used, expected: %d, got: %d", result.Remote.UsedGas, result.Local.UsedGas)
+	}
+}
+
+func TestDiffExecutionDivergence(t *testing.T) {
+	// BALANCE only charges for warm access while geth charges for cold access
+	result := diffCode(t, []evm.OpCode{
+		evm.PUSH1, 0x42,
+		evm.BALANCE,
+		evm.POP,
+		evm.STOP,
+	})
+	if result.Divergence == nil {
+		t.Fatalf("Expected a divergence")
+	}
+	if result.Divergence.Step != 2 || result.Divergence.Field != "gas" {
+		t.Fatalf("Invalid divergence, expected: step %d in %s, got: step %d in %s", 2, "gas", result.Divergence.Step, result.Divergence.Field)
+	}
+	if len(result.Divergence.Context) != 2 {
+		t.Fatalf("Invalid context length, expected: %d, got: %d", 2, len(result.Divergence.Context))
+	}
+	diff := result.Divergence.Local.Gas - result.Divergence.Remote.Gas
+	if diff != params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929 {
+		t.Fatalf("Invalid gas difference, expected: %d, got: %d", params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929, diff)
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestDiskStorage(t *testing.T) {
+	path := t.TempDir()
+	storage, err := evm.NewDiskStorage(path, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to open disk storage: %v", err)
+	}
+	for address, account := range newTestStorage().Alloc() {
+		storage.CreateAccount(address)
+		storage.SetBalance(address, uint256.MustFromBig(account.Balance))
+		storage.SetCode(address, account.Code)
+	}
+
+	// Process the same block on top of the disk and the simple storage
+	block := newTestBlock(t, 1_000_000, []types.TxData{dynamicFeeTx(0, storeAndLog, 5)}, nil)
+	result, err := evm.NewBlockProcessor(params.MergedTestChainConfig, storage, nil, nil).Process(block)
+	if err != nil {
+		t.Fatalf("Failed to process block: %v", err)
+	}
+	expected, err := evm.NewBlockProcessor(params.MergedTestChainConfig, newTestStorage(), nil, nil).Process(block)
+	if err != nil {
+		t.Fatalf("Failed to process block: %v", err)
+	}
+	if result.Root != expected.Root {
+		t.Fatalf("Invalid state root, expected: %v, got: %v", expected.Root, result.Root)
+	}
+	storage.Close()
+
+	// The committed state is reopened
+	storage, err = evm.NewDiskStorage(path, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk storage: %v", err)
+	}
+	defer storage.Close()
+
+	if root, _ := storage.StateRoot(); root != expected.Root {
+		t.Fatalf("Invalid state root after reopening, expected: %v, got: %v", expected.Root, root)
+	}
+	if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != common.HexToHash("0x05") {
+		t.Fatalf("Invalid stored value, expected: %v, got: %v", common.HexToHash("0x05"), value)
+	}
+	if nonce, _ := storage.GetNonce(testAddress); nonce == nil || *nonce != 1 {
+		t.Fatalf("Invalid sender nonce, expected: %d, got: %v", 1, nonce)
+	}
+	if balance, _ := storage.GetBalance(common.HexToAddress("0x42")); balance != nil {
+		t.Fatalf("Invalid balance of missing account, expected: nil, got: %v", balance)
+	}
+}
+
+func TestDiskStorageDeleteAccount(t *testing.T) {
+	address := common.HexToAddress("0x3000")
+	storage, err := evm.NewDiskStorage(t.TempDir(), "", nil)
+	if err != nil {
+		t.Fatalf("Failed to open disk storage: %v", err)
+	}
+	defer storage.Close()
+
+	// Setting the nonce creates the account
+	storage.SetNonce(address, 1)
+	storage.SetState(address, common.Hash{}, common.HexToHash("0x01"))
+	if empty, _ := storage.Empty(address); empty {
+		t.Fatalf("Invalid emptiness, expected: %v, got: %v", false, empty)
+	}
+	if _, err := storage.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	if err := storage.DeleteAccount(address); err != nil {
+		t.Fatalf("Failed to delete account: %v", err)
+	}
+	if exists, _ := storage.Exist(address); exists {
+		t.Fatalf("Invalid existence of a deleted account, expected: %v, got: %v", false, exists)
+	}
+	if value, _ := storage.GetState(address, common.Hash{}); value != (common.Hash{}) {
+		t.Fatalf("Invalid slot of a deleted account, expected: %v, got: %v", common.Hash{}, value)
+	}
+	if root, _ := storage.StateRoot(); root != types.EmptyRootHash {
+		t.Fatalf("Invalid state root, expected: %v, got: %v", types.EmptyRootHash, root)
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestAccountExistence(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x3000")
+		slot    = common.BigToHash(common.Big1)
+	)
+	storage := evm.NewSimpleStorage(nil)
+	if exists, _ := storage.Exist(address); exists {
+		t.Fatalf("Invalid existence of a missing account, expected: %v, got: %v", false, exists)
+	}
+	if empty, _ := storage.Empty(address); !empty {
+		t.Fatalf("Invalid emptiness of a missing account, expected: %v, got: %v", true, empty)
+	}
+
+	// Setting the balance creates the account
+	storage.SetBalance(address, uint256.NewInt(1))
+	storage.SetState(address, slot, slot)
+	if exists, _ := storage.Exist(address); !exists {
+		t.Fatalf("Invalid existence after setting the balance, expected: %v, got: %v", true, exists)
+	}
+	storage.SetBalance(address, uint256.NewInt(0))
+	if empty, _ := storage.Empty(address); !empty {
+		t.Fatalf("Invalid emptiness with a zero balance, expected: %v, got: %v", true, empty)
+	}
+
+	// The overlay hides the deleted account along with its storage
+	overlay := evm.NewOverlayStorage(storage, nil)
+	overlay.DeleteAccount(address)
+	if exists, _ := overlay.Exist(address); exists {
+		t.Fatalf("Invalid existence of a deleted account, expected: %v, got: %v", false, exists)
+	}
+	if value, _ := overlay.GetState(address, slot); value != (common.Hash{}) {
+		t.Fatalf("Invalid slot of a deleted account, expected: %v, got: %v", common.Hash{}, value)
+	}
+	if exists, _ := storage.Exist(address); !exists {
+		t.Fatalf("Invalid existence in the backing storage, expected: %v, got: %v", true, exists)
+	}
+	diff, err := overlay.Diff()
+	if err != nil {
+		t.Fatalf("Failed to compute diff: %v", err)
+	}
+	if _, ok := diff.Post[address]; ok || diff.Pre[address] == nil {
+		t.Fatalf("Invalid diff of a deleted account, expected it in pre only, got: %v", diff)
+	}
+
+	storage.DeleteAccount(address)
+	if balance, _ := storage.GetBalance(address); balance != nil {
+		t.Fatalf("Invalid balance of a deleted account, expected: %v, got: %v", nil, balance)
+	}
+	if value, _ := storage.GetState(address, slot); value != (common.Hash{}) {
+		t.Fatalf("Invalid slot of a deleted account, expected: %v, got: %v", common.Hash{}, value)
+	}
+}
+
+func TestEmptyAccountDeletion(t *testing.T) {
+	var (
+		empty   = common.HexToAddress("0x3000")
+		missing = common.HexToAddress("0x4000")
+		block   = evm.BlockContext{GasLimit: 30_000_000}
+	)
+	storage := newTestStorage()
+	storage.CreateAccount(empty)
+
+	// A touched empty account is deleted, a missing one isn't created
+	for _, to := range []common.Address{empty, missing} {
+		if _, err := evm.ApplyMessage(params.MergedTestChainConfig, block, storage, bundleMessage(to, 0), nil); err != nil {
+			t.Fatalf("Failed to apply message: %v", err)
+		}
+		if exists, _ := storage.Exist(to); exists {
+			t.Fatalf("Invalid existence of %v, expected: %v, got: %v", to, false, exists)
+		}
+	}
+
+	// The state root matches the one of a state which never had the account
+	clean := newTestStorage()
+	for i := 0; i < 2; i++ {
+		evm.ApplyMessage(params.MergedTestChainConfig, block, clean, bundleMessage(missing, 0), nil)
+	}
+	root, _ := storage.StateRoot()
+	if expected, _ := clean.StateRoot(); root != expected {
+		t.Fatalf("Invalid state root, expected: %v, got: %v", expected, root)
+	}
+
+	// A value transfer creates the recipient
+	if _, err := evm.ApplyMessage(params.MergedTestChainConfig, block, storage, bundleMessage(missing, 1), nil); err != nil {
+		t.Fatalf("Failed to apply message: %v", err)
+	}
+	if exists, _ := storage.Exist(missing); !exists {
+		t.Fatalf("Invalid existence of the recipient, expected: %v, got: %v", true, exists)
+	}
+
+	// A failed creation leaves no account behind
+	nonce, _ := storage.GetNonce(testAddress)
+	creation := bundleMessage(common.Address{}, 0)
+	creation.To = nil
+	creation.Data = toCode([]evm.OpCode{evm.PUSH1, 0x0, evm.PUSH1, 0x0, evm.REVERT})
+	result, err := evm.ApplyMessage(params.MergedTestChainConfig, block, storage, creation, nil)
+	if err != nil || !result.Failed() {
+		t.Fatalf("Expected creation to revert, got: %v %v", result, err)
+	}
+	if exists, _ := storage.Exist(crypto.CreateAddress(testAddress, *nonce)); exists {
+		t.Fatalf("Invalid existence of the failed contract, expected: %v, got: %v", false, exists)
+	}
+
+	// Before EIP-158, calls create the recipient and empty accounts are kept
+	config := &params.ChainConfig{ChainID: big.NewInt(1), HomesteadBlock: big.NewInt(0)}
+	created := common.HexToAddress("0x5000")
+	if _, err := evm.ApplyMessage(config, block, storage, bundleMessage(created, 0), nil); err != nil {
+		t.Fatalf("Failed to apply message: %v", err)
+	}
+	if exists, _ := storage.Exist(created); !exists {
+		t.Fatalf("Invalid existence before EIP-158, expected: %v, got: %v", true, exists)
+	}
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"errors"
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestEstimateGas(t *testing.T) {
+	storage := newTestStorage()
+	block := evm.BlockContext{GasLimit: 1_000_000}
+
+	msg := &evm.Message{From: testAddress, To: &storeAndLog, Value: uint256.NewInt(5)}
+	gas, err := evm.EstimateGas(params.MergedTestChainConfig, block, storage, msg, nil)
+	if err != nil {
+		t.Fatalf("Failed to estimate gas: %v", err)
+	}
+
+	// The estimate must succeed while one gas less must fail
+	for _, limit := range []uint64{gas, gas - 1} {
+		call := *msg
+		call.GasLimit = limit
+		result, err := evm.Call(params.MergedTestChainConfig, block, storage, &call, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to execute call: %v", err)
+		}
+		if expected := limit == gas; result.Failed() == expected {
+			t.Fatalf("Invalid call result with %d gas, expected success: %v, got: %v", limit, expected, result.Err)
+		}
+	}
+
+	// The storage must not have been modified
+	if nonce, _ := storage.GetNonce(testAddress); *nonce != 0 {
+		t.Fatalf("Invalid nonce, expected: %v, got: %v", 0, *nonce)
+	}
+
+	msg = &evm.Message{From: testAddress, To: &reverter}
+	_, err = evm.EstimateGas(params.MergedTestChainConfig, block, storage, msg, nil)
+	var revertErr *evm.RevertError
+	if !errors.As(err, &revertErr) {
+		t.Fatalf("Invalid error, expected: %v, got: %v", evm.ErrExecutionReverted, err)
+	}
+}
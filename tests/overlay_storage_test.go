@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+func TestOverlayStorageDiff(t *testing.T) {
+	var (
+		created = common.HexToAddress("0x3000")
+		slot    = common.BigToHash(common.Big1)
+		value   = common.BigToHash(common.Big2)
+	)
+	backing := newTestStorage()
+	backing.SetState(storeAndLog, slot, common.BigToHash(common.Big1))
+
+	overlay := evm.NewOverlayStorage(backing, nil)
+	overlay.SetBalance(testAddress, uint256.NewInt(1))
+	overlay.SetState(storeAndLog, slot, value)
+	overlay.CreateAccount(created)
+	overlay.SetNonce(created, 1)
+
+	// Reads of unmodified accounts are not part of the diff
+	overlay.GetBalance(reverter)
+
+	diff, err := overlay.Diff()
+	if err != nil {
+		t.Fatalf("Failed to compute diff: %v", err)
+	}
+	if len(diff.Post) != 3 || len(diff.Pre) != 2 {
+		t.Fatalf("Invalid diff size, expected: %d post and %d pre, got: %d and %d", 3, 2, len(diff.Post), len(diff.Pre))
+	}
+	if balance := diff.Post[testAddress].Balance; balance == nil || balance.Uint64() != 1 {
+		t.Fatalf("Invalid post balance, expected: %d, got: %v", 1, balance)
+	}
+	if diff.Pre[storeAndLog].Storage[slot] != common.BigToHash(common.Big1) || diff.Post[storeAndLog].Storage[slot] != value {
+		t.Fatalf("Invalid slot diff, expected: %v -> %v, got: %v -> %v", common.BigToHash(common.Big1), value, diff.Pre[storeAndLog].Storage[slot], diff.Post[storeAndLog].Storage[slot])
+	}
+	if _, ok := diff.Pre[created]; ok {
+		t.Fatalf("Invalid pre state, expected no entry for the created account")
+	}
+
+	// The backing storage is never written
+	if got, _ := backing.GetState(storeAndLog, slot); got != common.BigToHash(common.Big1) {
+		t.Fatalf("Invalid backing slot, expected: %v, got: %v", common.BigToHash(common.Big1), got)
+	}
+
+	overlay.Discard()
+	if got, _ := overlay.GetState(storeAndLog, slot); got != common.BigToHash(common.Big1) {
+		t.Fatalf("Invalid slot after discard, expected: %v, got: %v", common.BigToHash(common.Big1), got)
+	}
+	if diff, _ := overlay.Diff(); len(diff.Post) != 0 {
+		t.Fatalf("Invalid diff size after discard, expected: %d, got: %d", 0, len(diff.Post))
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// checkParallelResults checks that every message stored its own value in
+// slot 0 of storeAndLog, unaffected by the other messages
+func checkParallelResults(t *testing.T, results []*evm.ParallelResult, pre common.Hash) {
+	for i, result := range results {
+		if result.Err != nil || result.Failed() {
+			t.Fatalf("Failed to simulate message %d: %v %v", i, result.Err, result.ExecutionResult.Err)
+		}
+		diff := result.StateDiff
+		if value := diff.Pre[storeAndLog].Storage[common.Hash{}]; value != pre {
+			t.Fatalf("Invalid pre state of message %d, expected: %v, got: %v", i, pre, value)
+		}
+		if value := diff.Post[storeAndLog].Storage[common.Hash{}]; value != common.BigToHash(big.NewInt(int64(i+10))) {
+			t.Fatalf("Invalid post state of message %d, expected: %v, got: %v", i, common.BigToHash(big.NewInt(int64(i+10))), value)
+		}
+	}
+}
+
+func TestSimulateParallel(t *testing.T) {
+	storage := newTestStorage()
+	msgs := make([]*evm.Message, 200)
+	for i := range msgs {
+		msgs[i] = bundleMessage(storeAndLog, uint64(i+10))
+	}
+	msgs = append(msgs, bundleMessage(reverter, 0))
+
+	results := evm.SimulateParallel(params.MergedTestChainConfig, evm.BlockContext{GasLimit: 30_000_000}, storage, msgs, 8)
+	if len(results) != len(msgs) {
+		t.Fatalf("Invalid number of results, expected: %d, got: %d", len(msgs), len(results))
+	}
+	checkParallelResults(t, results[:200], common.Hash{})
+	if !results[200].Failed() {
+		t.Fatalf("Expected message %d to revert", 200)
+	}
+
+	// Nothing is committed to the storage
+	if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != (common.Hash{}) {
+		t.Fatalf("Invalid slot in storage, expected: %v, got: %v", common.Hash{}, value)
+	}
+}
+
+func TestRemoteStorageParallel(t *testing.T) {
+	testRemoteStorageParallel(t, newTestDatadir(t, "leveldb", rawdb.HashScheme, 2))
+
+	// Without the snapshot, the readers go through their own trie handles
+	path := newTestDatadir(t, "leveldb", rawdb.HashScheme, 2)
+	db := openTestDatabase(t, path)
+	rawdb.DeleteSnapshotRoot(db)
+	db.Close()
+	testRemoteStorageParallel(t, path)
+}
+
+func testRemoteStorageParallel(t *testing.T, path string) {
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	// Concurrent reads go through the shared caches and tries
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if value, err := storage.GetState(storeAndLog, common.BigToHash(big.NewInt(int64(j%4)))); err != nil {
+					t.Errorf("Failed to read slot: %v", err)
+				} else if j%4 == 1 && value != common.BigToHash(common.Big2) {
+					t.Errorf("Invalid slot, expected: %v, got: %v", common.BigToHash(common.Big2), value)
+				}
+				if _, err := storage.GetBalance(testAddress); err != nil {
+					t.Errorf("Failed to read balance: %v", err)
+				}
+				if _, err := storage.GetCode(storeAndLog); err != nil {
+					t.Errorf("Failed to read code: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := storage.Stats(); stats.SlotHits+stats.SlotMisses != 16*50 {
+		t.Fatalf("Invalid number of slot reads, expected: %d, got: %d", 16*50, stats.SlotHits+stats.SlotMisses)
+	}
+
+	msgs := make([]*evm.Message, 100)
+	for i := range msgs {
+		msgs[i] = bundleMessage(storeAndLog, uint64(i+10))
+	}
+	block := evm.NewBlockContext(storage.Header(), storage.GetHash)
+	results := evm.SimulateParallel(params.AllEthashProtocolChanges, block, storage, msgs, 0)
+	checkParallelResults(t, results, common.BigToHash(common.Big2))
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestProof(t *testing.T) {
+	storage, err := evm.NewRemoteStorage(newTestDatadir(t, "leveldb", rawdb.HashScheme, 2), nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+	root, _ := storage.StateRoot()
+
+	keys := []common.Hash{{}, common.BigToHash(common.Big1), common.BigToHash(common.Big3)}
+	result, err := storage.GetProof(storeAndLog, keys)
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	if err := evm.VerifyProof(root, result); err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	for i, expected := range []int64{2, 2, 0} {
+		if value := result.StorageProof[i].Value.ToInt(); value.Int64() != expected {
+			t.Fatalf("Invalid value of slot %d, expected: %d, got: %v", i, expected, value)
+		}
+	}
+
+	// The proven values can't be altered
+	result.StorageProof[2].Value = (*hexutil.Big)(big.NewInt(1))
+	if err := evm.VerifyProof(root, result); err == nil {
+		t.Fatalf("Invalid verification of altered slot, expected an error")
+	}
+	result.StorageProof[2].Value = (*hexutil.Big)(new(big.Int))
+	result.Balance = (*hexutil.Big)(big.NewInt(1))
+	if err := evm.VerifyProof(root, result); err == nil {
+		t.Fatalf("Invalid verification of altered balance, expected an error")
+	}
+
+	// Missing accounts are proven to be absent
+	missing, err := storage.GetProof(common.HexToAddress("0x42"), keys[:1])
+	if err != nil {
+		t.Fatalf("Failed to generate proof of missing account: %v", err)
+	}
+	if missing.StorageHash != types.EmptyRootHash || len(missing.StorageProof[0].Proof) != 0 {
+		t.Fatalf("Invalid proof of missing account, got: %+v", missing)
+	}
+	if err := evm.VerifyProof(root, missing); err != nil {
+		t.Fatalf("Failed to verify proof of missing account: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testDatadirs are the database engines and state schemes of the datadirs the
+// remote storage is tested with
+var testDatadirs = []struct {
+	engine string
+	scheme string
+}{
+	{"leveldb", rawdb.HashScheme},
+	{"leveldb", rawdb.PathScheme},
+	{"pebble", rawdb.HashScheme},
+	{"pebble", rawdb.PathScheme},
+}
+
+// newTestDatadir creates a geth datadir with the given database engine, state
+// scheme and number of blocks, each sending a transaction to the storeAndLog
+// contract. The contract initially holds 2 in slot 1. The datadir has an empty
+// ancient store, see freezeTestBlocks.
+func newTestDatadir(t *testing.T, engine string, scheme string, blocks int) string {
+	path := t.TempDir()
+	db, err := rawdb.Open(rawdb.OpenOptions{Type: engine, Directory: path, AncientsDirectory: filepath.Join(path, "ancient"), Cache: 16, Handles: 16})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	genesis := &core.Genesis{
+		Config:  params.AllEthashProtocolChanges,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+		Alloc: types.GenesisAlloc{
+			testAddress: {Balance: big.NewInt(params.Ether)},
+			storeAndLog: {Code: newTestStorage().Alloc()[storeAndLog].Code, Storage: map[common.Hash]common.Hash{common.BigToHash(common.Big1): common.BigToHash(common.Big2)}},
+		},
+	}
+	_, chain, _ := core.GenerateChainWithGenesis(genesis, ethash.NewFaker(), blocks, func(i int, b *core.BlockGen) {
+		tx, err := types.SignNewTx(testKey, types.LatestSigner(genesis.Config), &types.DynamicFeeTx{
+			ChainID:   genesis.Config.ChainID,
+			Nonce:     b.TxNonce(testAddress),
+			To:        &storeAndLog,
+			Value:     big.NewInt(int64(i + 1)),
+			Gas:       100_000,
+			GasFeeCap: big.NewInt(2 * params.InitialBaseFee),
+			GasTipCap: big.NewInt(params.GWei),
+		})
+		if err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		b.AddTx(tx)
+	})
+
+	// Disable the dirty cache so that the state of every block is written
+	cacheConfig := core.DefaultCacheConfigWithScheme(scheme)
+	cacheConfig.TrieDirtyDisabled = true
+	blockchain, err := core.NewBlockChain(db, cacheConfig, genesis, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("Failed to insert chain: %v", err)
+	}
+	blockchain.Stop()
+	return path
+}
+
+// openTestDatabase opens the key value store and the ancient store of the
+// datadir for writing, detecting its database engine
+func openTestDatabase(t *testing.T, path string) ethdb.Database {
+	db, err := rawdb.Open(rawdb.OpenOptions{Type: rawdb.PreexistingDatabase(path), Directory: path, AncientsDirectory: filepath.Join(path, "ancient"), Cache: 16, Handles: 16})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+// freezeTestBlocks moves the canonical blocks below the limit into the ancient
+// store and deletes them from the key value store, as the freezer of geth does
+// (the genesis block is kept in both)
+func freezeTestBlocks(t *testing.T, path string, limit uint64) {
+	db := openTestDatabase(t, path)
+	defer db.Close()
+
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+	)
+	for number := uint64(0); number < limit; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		blocks = append(blocks, rawdb.ReadBlock(db, hash, number))
+		receipts = append(receipts, rawdb.ReadRawReceipts(db, hash, number))
+	}
+	td := rawdb.ReadTd(db, blocks[0].Hash(), 0)
+	if _, err := rawdb.WriteAncientBlocks(db, blocks, receipts, td); err != nil {
+		t.Fatalf("Failed to write ancient blocks: %v", err)
+	}
+	batch := db.NewBatch()
+	for _, block := range blocks[1:] {
+		rawdb.DeleteBlockWithoutNumber(batch, block.Hash(), block.NumberU64())
+		rawdb.DeleteCanonicalHash(batch, block.NumberU64())
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("Failed to delete frozen blocks: %v", err)
+	}
+}
+
+func TestRemoteStorageCaches(t *testing.T) {
+	for _, datadir := range testDatadirs {
+		t.Run(datadir.engine+"/"+datadir.scheme, func(t *testing.T) {
+			testRemoteStorageCaches(t, newTestDatadir(t, datadir.engine, datadir.scheme, 2))
+		})
+	}
+}
+
+func testRemoteStorageCaches(t *testing.T, path string) {
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	// The last transaction stored its value in slot 0
+	for i := 0; i < 3; i++ {
+		if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != common.BigToHash(common.Big2) {
+			t.Fatalf("Invalid slot, expected: %v, got: %v", common.BigToHash(common.Big2), value)
+		}
+		if value, _ := storage.GetState(storeAndLog, common.BigToHash(common.Big1)); value != common.BigToHash(common.Big2) {
+			t.Fatalf("Invalid slot, expected: %v, got: %v", common.BigToHash(common.Big2), value)
+		}
+		if nonce, _ := storage.GetNonce(testAddress); nonce == nil || *nonce != 2 {
+			t.Fatalf("Invalid nonce, expected: %d, got: %v", 2, nonce)
+		}
+	}
+	if balance, _ := storage.GetBalance(common.HexToAddress("0x42")); balance != nil {
+		t.Fatalf("Invalid balance of missing account, expected: nil, got: %v", balance)
+	}
+
+	stats := storage.Stats()
+	expected := evm.CacheStats{
+		AccountHits:          2,
+		AccountMisses:        2,
+		SlotHits:             4,
+		SlotMisses:           2,
+		AccountSnapshotReads: 2,
+		SlotSnapshotReads:    2,
+	}
+	if stats != expected {
+		t.Fatalf("Invalid cache stats, expected: %+v, got: %+v", expected, stats)
+	}
+}
+
+func TestRemoteStorageSnapshotFallback(t *testing.T) {
+	for _, datadir := range testDatadirs {
+		t.Run(datadir.engine+"/"+datadir.scheme, func(t *testing.T) {
+			testRemoteStorageSnapshotFallback(t, newTestDatadir(t, datadir.engine, datadir.scheme, 2))
+		})
+	}
+}
+
+func testRemoteStorageSnapshotFallback(t *testing.T, path string) {
+	// Drop the snapshot so that the reads are served by the tries
+	db := openTestDatabase(t, path)
+	rawdb.DeleteSnapshotRoot(db)
+	db.Close()
+
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != common.BigToHash(common.Big2) {
+		t.Fatalf("Invalid slot, expected: %v, got: %v", common.BigToHash(common.Big2), value)
+	}
+	if nonce, _ := storage.GetNonce(testAddress); nonce == nil || *nonce != 2 {
+		t.Fatalf("Invalid nonce, expected: %d, got: %v", 2, nonce)
+	}
+
+	stats := storage.Stats()
+	expected := evm.CacheStats{
+		AccountMisses:    2,
+		TrieMisses:       1,
+		SlotMisses:       1,
+		AccountTrieReads: 2,
+		SlotTrieReads:    1,
+	}
+	if stats != expected {
+		t.Fatalf("Invalid cache stats, expected: %+v, got: %+v", expected, stats)
+	}
+}
+
+func TestRemoteStorageIterateStorage(t *testing.T) {
+	for _, datadir := range testDatadirs {
+		t.Run(datadir.engine+"/"+datadir.scheme, func(t *testing.T) {
+			testRemoteStorageIterateStorage(t, newTestDatadir(t, datadir.engine, datadir.scheme, 2))
+		})
+	}
+}
+
+func testRemoteStorageIterateStorage(t *testing.T, path string) {
+	// Only the preimage of slot 1 is known
+	slot := common.BigToHash(common.Big1)
+	db := openTestDatabase(t, path)
+	rawdb.WritePreimages(db, map[common.Hash][]byte{crypto.Keccak256Hash(slot.Bytes()): slot.Bytes()})
+	db.Close()
+
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	slots := make(map[common.Hash]evm.StorageSlot)
+	err = storage.IterateStorage(storeAndLog, func(slot evm.StorageSlot) error {
+		slots[slot.Hash] = slot
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to iterate storage: %v", err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("Invalid number of slots, expected: %d, got: %d", 2, len(slots))
+	}
+	known := slots[crypto.Keccak256Hash(slot.Bytes())]
+	if known.Key == nil || *known.Key != slot || known.Value != common.BigToHash(common.Big2) {
+		t.Fatalf("Invalid slot with preimage, expected: %v = %v, got: %v = %v", slot, common.BigToHash(common.Big2), known.Key, known.Value)
+	}
+	unknown := slots[crypto.Keccak256Hash(common.Hash{}.Bytes())]
+	if unknown.Key != nil || unknown.Value != common.BigToHash(common.Big2) {
+		t.Fatalf("Invalid slot without preimage, expected: %v, got: %v = %v", common.BigToHash(common.Big2), unknown.Key, unknown.Value)
+	}
+
+	if err := storage.IterateStorage(common.HexToAddress("0x42"), func(evm.StorageSlot) error { return nil }); err == nil {
+		t.Fatalf("Invalid iteration of a missing account, expected an error")
+	}
+}
+
+func TestRemoteStorageAt(t *testing.T) {
+	for _, datadir := range testDatadirs {
+		t.Run(datadir.engine+"/"+datadir.scheme, func(t *testing.T) {
+			testRemoteStorageAt(t, newTestDatadir(t, datadir.engine, datadir.scheme, 3))
+		})
+	}
+}
+
+func testRemoteStorageAt(t *testing.T, path string) {
+	// A header of a block which is known but whose state was never persisted
+	db := openTestDatabase(t, path)
+	hash := rawdb.ReadCanonicalHash(db, 1)
+	missing := types.CopyHeader(rawdb.ReadHeader(db, hash, 1))
+	missing.Root = crypto.Keccak256Hash([]byte("missing"))
+	rawdb.WriteHeader(db, missing)
+	db.Close()
+
+	// Block 1 is opened both by number and by hash
+	for _, block := range []rpc.BlockNumberOrHash{rpc.BlockNumberOrHashWithNumber(1), rpc.BlockNumberOrHashWithHash(hash, true)} {
+		storage, err := evm.NewRemoteStorageAt(path, block, nil)
+		if err != nil {
+			t.Fatalf("Failed to open remote storage at %v: %v", block, err)
+		}
+		if header := storage.Header(); header.Hash() != hash {
+			t.Fatalf("Invalid header, expected: %v, got: %v", hash, header.Hash())
+		}
+		// The first transaction stored its value in slot 0 and sent 1 wei
+		if value, _ := storage.GetState(storeAndLog, common.Hash{}); value != common.BigToHash(common.Big1) {
+			t.Fatalf("Invalid slot, expected: %v, got: %v", common.BigToHash(common.Big1), value)
+		}
+		if balance, _ := storage.GetBalance(storeAndLog); balance == nil || balance.Uint64() != 1 {
+			t.Fatalf("Invalid balance, expected: %d, got: %v", 1, balance)
+		}
+		if nonce, _ := storage.GetNonce(testAddress); nonce == nil || *nonce != 1 {
+			t.Fatalf("Invalid nonce, expected: %d, got: %v", 1, nonce)
+		}
+		storage.Close()
+	}
+
+	_, err := evm.NewRemoteStorageAt(path, rpc.BlockNumberOrHashWithHash(missing.Hash(), false), nil)
+	if err == nil || !strings.Contains(err.Error(), "has been pruned or not synced yet") {
+		t.Fatalf("Invalid error of a missing state, expected: %v, got: %v", "has been pruned or not synced yet", err)
+	}
+}
+
+func TestRemoteStorageAncients(t *testing.T) {
+	for _, datadir := range testDatadirs {
+		t.Run(datadir.engine+"/"+datadir.scheme, func(t *testing.T) {
+			testRemoteStorageAncients(t, newTestDatadir(t, datadir.engine, datadir.scheme, 3))
+		})
+	}
+}
+
+func testRemoteStorageAncients(t *testing.T, path string) {
+	// Index the transaction of block 2, then freeze the blocks up to it
+	db := openTestDatabase(t, path)
+	var hashes []common.Hash
+	for number := uint64(0); number <= 3; number++ {
+		hashes = append(hashes, rawdb.ReadCanonicalHash(db, number))
+	}
+	block := rawdb.ReadBlock(db, hashes[2], 2)
+	rawdb.WriteTxLookupEntriesByBlock(db, block)
+	db.Close()
+	freezeTestBlocks(t, path, 3)
+
+	// Only the ancient store has the frozen blocks
+	db = openTestDatabase(t, path)
+	for number := uint64(1); number < 3; number++ {
+		if frozen := rawdb.ReadAllHashes(db, number); len(frozen) != 0 {
+			t.Fatalf("Invalid key value store of frozen block %d, expected no headers, got: %v", number, frozen)
+		}
+	}
+	db.Close()
+
+	storage, err := evm.NewRemoteStorageAt(path, rpc.BlockNumberOrHashWithNumber(2), nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	if header := storage.Header(); header.Hash() != hashes[2] {
+		t.Fatalf("Invalid header, expected: %v, got: %v", hashes[2], header.Hash())
+	}
+	for number, hash := range hashes {
+		if got := storage.GetHash(uint64(number)); got != hash {
+			t.Fatalf("Invalid hash of block %d, expected: %v, got: %v", number, hash, got)
+		}
+	}
+	if header := storage.GetHeader(hashes[1], 1); header == nil || header.Hash() != hashes[1] {
+		t.Fatalf("Invalid header of block %d, expected: %v, got: %v", 1, hashes[1], header)
+	}
+
+	result, err := evm.ReplayTransaction(storage, block.Transactions()[0].Hash(), nil)
+	if err != nil {
+		t.Fatalf("Failed to replay transaction: %v", err)
+	}
+	// The stored receipt is read from the ancient store as well. Only the gas
+	// differs, as the interpreter charges SSTORE the warm cost only.
+	if result.Expected == nil || result.Expected.TxHash != block.Transactions()[0].Hash() {
+		t.Fatalf("Invalid stored receipt, expected: %v, got: %v", block.Transactions()[0].Hash(), result.Expected)
+	}
+	if result.Local.Status != result.Expected.Status || len(result.Local.Logs) != len(result.Expected.Logs) {
+		t.Fatalf("Invalid replay, expected: status %d with %d logs, got: status %d with %d logs", result.Expected.Status, len(result.Expected.Logs), result.Local.Status, len(result.Local.Logs))
+	}
+}
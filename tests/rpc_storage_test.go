@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"goevm/evm"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testEthAPI serves the state of a single account at block 10
+type testEthAPI struct {
+	calls int
+}
+
+func (api *testEthAPI) ChainId() *hexutil.Big {
+	api.calls++
+	return (*hexutil.Big)(big.NewInt(1337))
+}
+
+func (api *testEthAPI) BlockNumber() hexutil.Uint64 {
+	api.calls++
+	return 10
+}
+
+func (api *testEthAPI) GetBlockByNumber(number rpc.BlockNumber, full bool) *types.Header {
+	api.calls++
+	return &types.Header{
+		Number:     big.NewInt(number.Int64()),
+		Difficulty: common.Big0,
+		GasLimit:   30_000_000,
+		Time:       uint64(number.Int64()) * 12,
+		BaseFee:    big.NewInt(7),
+	}
+}
+
+func (api *testEthAPI) GetBalance(address common.Address, block rpc.BlockNumberOrHash) *hexutil.Big {
+	api.calls++
+	if address == storeAndLog {
+		return (*hexutil.Big)(big.NewInt(100))
+	}
+	return (*hexutil.Big)(common.Big0)
+}
+
+func (api *testEthAPI) GetTransactionCount(address common.Address, block rpc.BlockNumberOrHash) hexutil.Uint64 {
+	api.calls++
+	return 0
+}
+
+func (api *testEthAPI) GetCode(address common.Address, block rpc.BlockNumberOrHash) hexutil.Bytes {
+	api.calls++
+	return nil
+}
+
+func (api *testEthAPI) GetStorageAt(address common.Address, key common.Hash, block rpc.BlockNumberOrHash) hexutil.Bytes {
+	api.calls++
+	if number, _ := block.Number(); number != 10 {
+		return common.Hash{}.Bytes()
+	}
+	return common.BigToHash(big.NewInt(42)).Bytes()
+}
+
+func TestRPCStorage(t *testing.T) {
+	api := new(testEthAPI)
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatalf("Failed to register api: %v", err)
+	}
+	endpoint := httptest.NewServer(server)
+	cacheDir := t.TempDir()
+
+	storage, err := evm.NewRPCStorage(endpoint.URL, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), cacheDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to rpc endpoint: %v", err)
+	}
+	if number := storage.Header().Number.Uint64(); number != 10 {
+		t.Fatalf("Invalid pinned block, expected: %d, got: %d", 10, number)
+	}
+	if balance, _ := storage.GetBalance(storeAndLog); balance == nil || balance.Uint64() != 100 {
+		t.Fatalf("Invalid balance, expected: %d, got: %v", 100, balance)
+	}
+	if balance, _ := storage.GetBalance(testAddress); balance != nil {
+		t.Fatalf("Invalid balance of empty account, expected: nil, got: %v", balance)
+	}
+	slot := common.BigToHash(common.Big1)
+	if value, _ := storage.GetState(storeAndLog, slot); value != common.BigToHash(big.NewInt(42)) {
+		t.Fatalf("Invalid slot, expected: %v, got: %v", common.BigToHash(big.NewInt(42)), value)
+	}
+
+	// Repeated reads are served from the cache
+	calls := api.calls
+	storage.GetState(storeAndLog, slot)
+	storage.GetBalance(storeAndLog)
+	if api.calls != calls {
+		t.Fatalf("Invalid number of calls, expected: %d, got: %d", calls, api.calls)
+	}
+	storage.Close()
+
+	// Once the endpoint is gone, the reads at the same block are served from
+	// disk, with latest being the block it last resolved to
+	endpoint.Close()
+	for _, block := range []rpc.BlockNumberOrHash{rpc.BlockNumberOrHashWithNumber(10), rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)} {
+		storage, err = evm.NewRPCStorage(endpoint.URL, block, cacheDir, nil)
+		if err != nil {
+			t.Fatalf("Failed to open rpc storage offline at %v: %v", block, err)
+		}
+		if number := storage.Header().Number.Uint64(); number != 10 {
+			t.Fatalf("Invalid pinned block, expected: %d, got: %d", 10, number)
+		}
+		storage.Close()
+	}
+
+	// Without a cached latest block, an explicit block is needed
+	_, err = evm.NewRPCStorage(endpoint.URL, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), t.TempDir(), nil)
+	if err == nil || !strings.Contains(err.Error(), "an explicit --block is needed") {
+		t.Fatalf("Invalid error of an offline latest block, expected: %v, got: %v", "an explicit --block is needed", err)
+	}
+
+	storage, err = evm.NewRPCStorage(endpoint.URL, rpc.BlockNumberOrHashWithNumber(10), cacheDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to open rpc storage offline: %v", err)
+	}
+	defer storage.Close()
+	if value, _ := storage.GetState(storeAndLog, slot); value != common.BigToHash(big.NewInt(42)) {
+		t.Fatalf("Invalid cached slot, expected: %v, got: %v", common.BigToHash(big.NewInt(42)), value)
+	}
+	if balance, _ := storage.GetBalance(storeAndLog); balance == nil || balance.Uint64() != 100 {
+		t.Fatalf("Invalid cached balance, expected: %d, got: %v", 100, balance)
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+)
+
+func TestSimpleStorageStateRoot(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetState(storeAndLog, common.Hash{}, common.BigToHash(uint256.NewInt(5).ToBig()))
+	storage.SetState(storeAndLog, common.HexToHash("0x01"), common.HexToHash("0xff00"))
+	// Zero slots are not part of the storage trie
+	storage.SetState(reverter, common.HexToHash("0x02"), common.Hash{})
+	storage.SetNonce(testAddress, 3)
+
+	// Build the same state with geth
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for _, address := range []common.Address{testAddress, storeAndLog, reverter} {
+		balance, _ := storage.GetBalance(address)
+		nonce, _ := storage.GetNonce(address)
+		statedb.CreateAccount(address)
+		statedb.SetBalance(address, balance, tracing.BalanceChangeUnspecified)
+		statedb.SetNonce(address, *nonce)
+		if code, _ := storage.GetCode(address); len(code) > 0 {
+			statedb.SetCode(address, code)
+		}
+	}
+	statedb.SetState(storeAndLog, common.Hash{}, common.BigToHash(uint256.NewInt(5).ToBig()))
+	statedb.SetState(storeAndLog, common.HexToHash("0x01"), common.HexToHash("0xff00"))
+	expected := statedb.IntermediateRoot(false)
+
+	root, err := storage.StateRoot()
+	if err != nil {
+		t.Fatalf("Failed to compute state root: %v", err)
+	}
+	if root != expected {
+		t.Fatalf("Invalid state root, expected: %v, got: %v", expected, root)
+	}
+
+	// The root must follow the changes of the state
+	storage.SetState(storeAndLog, common.HexToHash("0x01"), common.Hash{})
+	statedb.SetState(storeAndLog, common.HexToHash("0x01"), common.Hash{})
+	expected = statedb.IntermediateRoot(false)
+	if root, _ := storage.StateRoot(); root != expected {
+		t.Fatalf("Invalid state root after update, expected: %v, got: %v", expected, root)
+	}
+}
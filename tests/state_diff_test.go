@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/json"
+	"goevm/evm"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestCallStateDiff(t *testing.T) {
+	slot := common.Hash{}
+	overrides := evm.StateOverride{storeAndLog: {StateDiff: map[common.Hash]common.Hash{slot: common.BigToHash(common.Big3)}}}
+	msg := &evm.Message{From: testAddress, To: &storeAndLog, Value: uint256.NewInt(5)}
+
+	_, diff, err := evm.CallWithStateDiff(params.MergedTestChainConfig, evm.BlockContext{GasLimit: 1_000_000}, newTestStorage(), msg, overrides, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute call: %v", err)
+	}
+
+	// The overridden slot is the pre state of the call
+	pre, post := diff.Pre[storeAndLog], diff.Post[storeAndLog]
+	if pre == nil || post == nil {
+		t.Fatalf("Invalid diff, expected the contract in pre and post, got: %v %v", pre, post)
+	}
+	if value := pre.Storage[slot]; value != common.BigToHash(common.Big3) {
+		t.Fatalf("Invalid pre slot, expected: %v, got: %v", common.BigToHash(common.Big3), value)
+	}
+	if value := post.Storage[slot]; value != common.BigToHash(big.NewInt(5)) {
+		t.Fatalf("Invalid post slot, expected: %v, got: %v", common.BigToHash(big.NewInt(5)), value)
+	}
+
+	// The pre state holds the whole account, the post state only the changes
+	if len(pre.Code) == 0 || pre.Balance == nil {
+		t.Fatalf("Invalid pre account, expected code and balance, got: %v", pre)
+	}
+	if post.Code != nil || post.Balance == nil || post.Balance.Uint64() != 5 {
+		t.Fatalf("Invalid post account, expected: balance %d only, got: %v", 5, post)
+	}
+}
+
+func TestStateDiffJSON(t *testing.T) {
+	var (
+		created = common.HexToAddress("0x3000")
+		nonce   = uint64(1)
+		slot1   = common.BigToHash(common.Big1)
+		slot2   = common.BigToHash(common.Big2)
+	)
+	diff := &evm.StateDiff{
+		Pre: map[common.Address]*evm.AccountState{
+			storeAndLog: {
+				Balance: uint256.NewInt(16),
+				Nonce:   new(uint64),
+				Code:    []byte{0x60, 0x00},
+				Storage: map[common.Hash]common.Hash{slot1: {}, slot2: slot1},
+			},
+		},
+		Post: map[common.Address]*evm.AccountState{
+			storeAndLog: {
+				Storage: map[common.Hash]common.Hash{slot1: slot2, slot2: {}},
+			},
+			created: {Balance: uint256.NewInt(0), Nonce: &nonce},
+		},
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatalf("Failed to encode diff: %v", err)
+	}
+
+	// Zero fields and slots are left out as in geth's prestateTracer
+	var decoded map[string]map[common.Address]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+	pre := decoded["pre"][storeAndLog]
+	if string(pre["balance"]) != `"0x10"` || string(pre["code"]) != `"0x6000"` {
+		t.Fatalf("Invalid pre account, expected: balance 0x10 and code 0x6000, got: %s", data)
+	}
+	if _, ok := pre["nonce"]; ok {
+		t.Fatalf("Invalid pre account, expected no zero nonce, got: %s", data)
+	}
+	if _, ok := decoded["pre"][created]; ok {
+		t.Fatalf("Invalid pre state, expected no created account, got: %s", data)
+	}
+	if string(decoded["post"][created]["balance"]) != `"0x0"` || string(decoded["post"][created]["nonce"]) != "1" {
+		t.Fatalf("Invalid created account, expected: balance 0x0 and nonce 1, got: %s", data)
+	}
+	var storage map[common.Hash]common.Hash
+	if err := json.Unmarshal(decoded["post"][storeAndLog]["storage"], &storage); err != nil || len(storage) != 1 {
+		t.Fatalf("Invalid post storage, expected: %d slot, got: %s", 1, data)
+	}
+
+	// The decoded diff matches, except for the zero values left out
+	roundtrip := new(evm.StateDiff)
+	if err := json.Unmarshal(data, roundtrip); err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+	if account := roundtrip.Pre[storeAndLog]; account.Balance.Uint64() != 16 || account.Storage[slot2] != slot1 || len(account.Storage) != 1 {
+		t.Fatalf("Invalid decoded pre account, got: %v", account)
+	}
+	if account := roundtrip.Post[created]; account.Nonce == nil || *account.Nonce != nonce || account.Balance.Sign() != 0 {
+		t.Fatalf("Invalid decoded created account, got: %v", account)
+	}
+}
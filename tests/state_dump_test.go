@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"goevm/evm"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+// newTestDump commits the state of the test storage with geth and returns
+// the state root along with the full and the iterative dumps of it
+func newTestDump(t *testing.T) (common.Hash, []byte, []byte) {
+	storage := newTestStorage()
+	storage.SetState(storeAndLog, common.Hash{}, common.HexToHash("0x2a"))
+
+	sdb := state.NewDatabaseWithConfig(rawdb.NewMemoryDatabase(), &triedb.Config{Preimages: true})
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatalf("Failed to create state: %v", err)
+	}
+	for address, account := range storage.Alloc() {
+		statedb.CreateAccount(address)
+		statedb.SetBalance(address, uint256.MustFromBig(account.Balance), tracing.BalanceChangeUnspecified)
+		statedb.SetNonce(address, account.Nonce)
+		statedb.SetCode(address, account.Code)
+		for key, value := range account.Storage {
+			statedb.SetState(address, key, value)
+		}
+	}
+	root, err := statedb.Commit(0, false)
+	if err != nil {
+		t.Fatalf("Failed to commit state: %v", err)
+	}
+	if statedb, err = state.New(root, sdb, nil); err != nil {
+		t.Fatalf("Failed to open state: %v", err)
+	}
+
+	var iterative bytes.Buffer
+	statedb.IterativeDump(nil, json.NewEncoder(&iterative))
+	return root, statedb.Dump(nil), iterative.Bytes()
+}
+
+func TestSimpleStorageImportState(t *testing.T) {
+	root, full, iterative := newTestDump(t)
+	dir := t.TempDir()
+
+	alloc, err := json.Marshal(newTestStorage().Alloc())
+	if err != nil {
+		t.Fatalf("Failed to encode alloc: %v", err)
+	}
+	genesis := []byte(`{"config": {"chainId": 1}, "alloc": ` + string(alloc) + `}`)
+
+	for name, data := range map[string][]byte{"full": full, "iterative": iterative, "genesis": genesis, "alloc": alloc} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write state: %v", err)
+		}
+		storage := evm.NewSimpleStorage(nil)
+		if err := storage.ImportState(path); err != nil {
+			t.Fatalf("Failed to import %s state: %v", name, err)
+		}
+		if code, _ := storage.GetCode(storeAndLog); !bytes.Equal(code, newTestStorage().Alloc()[storeAndLog].Code) {
+			t.Fatalf("Invalid code of %s state, expected: %x, got: %x", name, newTestStorage().Alloc()[storeAndLog].Code, code)
+		}
+		if name != "full" && name != "iterative" {
+			continue
+		}
+		// The dumps contain the written slot as well
+		if imported, _ := storage.StateRoot(); imported != root {
+			t.Fatalf("Invalid root of %s state, expected: %v, got: %v", name, root, imported)
+		}
+	}
+}
+
+func TestSimpleStorageExportState(t *testing.T) {
+	root, _, _ := newTestDump(t)
+	storage := newTestStorage()
+	storage.SetState(storeAndLog, common.Hash{}, common.HexToHash("0x2a"))
+
+	for _, format := range []string{evm.AllocFormat, evm.DumpFormat} {
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := storage.ExportState(path, format); err != nil {
+			t.Fatalf("Failed to export %s state: %v", format, err)
+		}
+		imported := evm.NewSimpleStorage(nil)
+		if err := imported.ImportState(path); err != nil {
+			t.Fatalf("Failed to import %s state: %v", format, err)
+		}
+		if value, _ := imported.GetState(storeAndLog, common.Hash{}); value != common.HexToHash("0x2a") {
+			t.Fatalf("Invalid slot of %s state, expected: %v, got: %v", format, common.HexToHash("0x2a"), value)
+		}
+		if importedRoot, _ := imported.StateRoot(); importedRoot != root {
+			t.Fatalf("Invalid root of %s state, expected: %v, got: %v", format, root, importedRoot)
+		}
+	}
+
+	// The dump carries the same root as geth
+	dump, err := storage.Dump()
+	if err != nil {
+		t.Fatalf("Failed to dump state: %v", err)
+	}
+	if common.HexToHash(dump.Root) != root {
+		t.Fatalf("Invalid dump root, expected: %v, got: %v", root, dump.Root)
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"errors"
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+var errCorrupted = errors.New("corrupted slot")
+
+// failingStorage fails the reads of the storage slots
+type failingStorage struct {
+	evm.Storage
+}
+
+func (s *failingStorage) GetState(common.Address, common.Hash) (common.Hash, error) {
+	return common.Hash{}, errCorrupted
+}
+
+func TestStorageErrorHalt(t *testing.T) {
+	storage := &failingStorage{newTestStorage()}
+
+	code := toCode([]evm.OpCode{
+		evm.PUSH1, 0x0,
+		evm.SLOAD,
+		evm.STOP,
+	})
+	opts := evm.NewExecutionOpts(storeAndLog, testAddress, 0, nil, code, 42000)
+	result := evm.NewEVM(evm.BlockContext{}, storage, opts, nil).Run()
+
+	var storageErr *evm.StorageError
+	if !errors.As(result.Err, &storageErr) || !errors.Is(result.Err, errCorrupted) {
+		t.Fatalf("Invalid execution error, expected: %v, got: %v", errCorrupted, result.Err)
+	}
+	if storageErr.Op != "SLOAD" || storageErr.Address != storeAndLog {
+		t.Fatalf("Invalid storage error, expected: SLOAD of %v, got: %s of %v", storeAndLog, storageErr.Op, storageErr.Address)
+	}
+
+	// A transaction hitting the failure has no valid outcome
+	msg := &evm.Message{
+		From:      testAddress,
+		To:        &storeAndLog,
+		Value:     uint256.NewInt(5),
+		GasLimit:  100_000,
+		GasPrice:  new(uint256.Int),
+		GasFeeCap: new(uint256.Int),
+		GasTipCap: new(uint256.Int),
+	}
+	if _, err := evm.ApplyMessage(params.MergedTestChainConfig, evm.BlockContext{}, storage, msg, nil); !errors.As(err, &storageErr) {
+		t.Fatalf("Invalid message error, expected: %T, got: %v", storageErr, err)
+	}
+}
+
+func TestRemoteStorageMissingCode(t *testing.T) {
+	path := newTestDatadir(t, "leveldb", rawdb.HashScheme, 1)
+
+	// Drop the code of the contract from the database
+	db := openTestDatabase(t, path)
+	rawdb.DeleteCode(db, crypto.Keccak256Hash(newTestStorage().Alloc()[storeAndLog].Code))
+	db.Close()
+
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+
+	if code, err := storage.GetCode(storeAndLog); err == nil {
+		t.Fatalf("Invalid read of missing code, expected an error, got: %x", code)
+	}
+	if _, err := storage.GetCode(reverter); err != nil {
+		t.Fatalf("Failed to read code of missing account: %v", err)
+	}
+}
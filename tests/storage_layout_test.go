@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"encoding/json"
+	"goevm/evm"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testLayout is the solc storage layout of the following contract:
+//
+//	struct S { uint128 a; uint128 b; address c; }
+//	address owner; bool paused; uint8 decimals; int16 delta;
+//	uint256 total; uint8[3] small; uint256[] list; string name; bytes data; S s;
+//	mapping(address => uint256) balances;
+//	mapping(address => mapping(address => uint256)) allowance;
+//	mapping(string => S) named;
+//	S[2] pair;
+const testLayout = `{
+  "storage": [
+    {"label": "owner", "offset": 0, "slot": "0", "type": "t_address"},
+    {"label": "paused", "offset": 20, "slot": "0", "type": "t_bool"},
+    {"label": "decimals", "offset": 21, "slot": "0", "type": "t_uint8"},
+    {"label": "delta", "offset": 22, "slot": "0", "type": "t_int16"},
+    {"label": "total", "offset": 0, "slot": "1", "type": "t_uint256"},
+    {"label": "small", "offset": 0, "slot": "2", "type": "t_array(t_uint8)3_storage"},
+    {"label": "list", "offset": 0, "slot": "3", "type": "t_array(t_uint256)dyn_storage"},
+    {"label": "name", "offset": 0, "slot": "4", "type": "t_string_storage"},
+    {"label": "data", "offset": 0, "slot": "5", "type": "t_bytes_storage"},
+    {"label": "s", "offset": 0, "slot": "6", "type": "t_struct(S)10_storage"},
+    {"label": "balances", "offset": 0, "slot": "8", "type": "t_mapping(t_address,t_uint256)"},
+    {"label": "allowance", "offset": 0, "slot": "9", "type": "t_mapping(t_address,t_mapping(t_address,t_uint256))"},
+    {"label": "named", "offset": 0, "slot": "10", "type": "t_mapping(t_string_memory_ptr,t_struct(S)10_storage)"},
+    {"label": "pair", "offset": 0, "slot": "11", "type": "t_array(t_struct(S)10_storage)2_storage"}
+  ],
+  "types": {
+    "t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+    "t_bool": {"encoding": "inplace", "label": "bool", "numberOfBytes": "1"},
+    "t_uint8": {"encoding": "inplace", "label": "uint8", "numberOfBytes": "1"},
+    "t_int16": {"encoding": "inplace", "label": "int16", "numberOfBytes": "2"},
+    "t_uint128": {"encoding": "inplace", "label": "uint128", "numberOfBytes": "16"},
+    "t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+    "t_array(t_uint8)3_storage": {"base": "t_uint8", "encoding": "inplace", "label": "uint8[3]", "numberOfBytes": "32"},
+    "t_array(t_uint256)dyn_storage": {"base": "t_uint256", "encoding": "dynamic_array", "label": "uint256[]", "numberOfBytes": "32"},
+    "t_string_storage": {"encoding": "bytes", "label": "string", "numberOfBytes": "32"},
+    "t_string_memory_ptr": {"encoding": "bytes", "label": "string", "numberOfBytes": "32"},
+    "t_bytes_storage": {"encoding": "bytes", "label": "bytes", "numberOfBytes": "32"},
+    "t_struct(S)10_storage": {"encoding": "inplace", "label": "struct C.S", "numberOfBytes": "64", "members": [
+      {"label": "a", "offset": 0, "slot": "0", "type": "t_uint128"},
+      {"label": "b", "offset": 16, "slot": "0", "type": "t_uint128"},
+      {"label": "c", "offset": 0, "slot": "1", "type": "t_address"}
+    ]},
+    "t_array(t_struct(S)10_storage)2_storage": {"base": "t_struct(S)10_storage", "encoding": "inplace", "label": "struct C.S[2]", "numberOfBytes": "128"},
+    "t_mapping(t_address,t_uint256)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => uint256)", "numberOfBytes": "32", "value": "t_uint256"},
+    "t_mapping(t_address,t_mapping(t_address,t_uint256))": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => mapping(address => uint256))", "numberOfBytes": "32", "value": "t_mapping(t_address,t_uint256)"},
+    "t_mapping(t_string_memory_ptr,t_struct(S)10_storage)": {"encoding": "mapping", "key": "t_string_memory_ptr", "label": "mapping(string => struct C.S)", "numberOfBytes": "32", "value": "t_struct(S)10_storage"}
+  }
+}`
+
+// flattenValues maps the name of every decoded value to its formatted value
+func flattenValues(values []*evm.StorageValue, flat map[string]*evm.StorageValue) {
+	for _, value := range values {
+		flat[value.Name] = value
+		flattenValues(value.Members, flat)
+	}
+}
+
+// slotAt returns the slot at the given offset from the hash of the data
+func slotAt(data []byte, offset int64) common.Hash {
+	return common.BigToHash(new(big.Int).Add(crypto.Keccak256Hash(data).Big(), big.NewInt(offset)))
+}
+
+func TestStorageDecoder(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x3000")
+		owner    = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		spender  = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		data     = common.FromHex("0x000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021222324252627")
+	)
+	slot := func(n int64) common.Hash { return common.BigToHash(big.NewInt(n)) }
+	storage := newTestStorage()
+	storage.CreateAccount(contract)
+
+	// owner, paused, decimals and delta (-2) are packed into slot 0
+	var packed common.Hash
+	copy(packed[12:], owner.Bytes())
+	packed[11], packed[10], packed[9], packed[8] = 1, 18, 0xfe, 0xff
+	storage.SetState(contract, slot(0), packed)
+	storage.SetState(contract, slot(1), slot(1000))
+	storage.SetState(contract, slot(2), common.HexToHash("0x030201"))
+	storage.SetState(contract, slot(3), slot(2))
+	storage.SetState(contract, slotAt(slot(3).Bytes(), 0), slot(7))
+	storage.SetState(contract, slotAt(slot(3).Bytes(), 1), slot(8))
+
+	// A short string is stored in place, long bytes at the hash of the slot
+	var name common.Hash
+	copy(name[:], "goevm")
+	name[31] = 10
+	storage.SetState(contract, slot(4), name)
+	storage.SetState(contract, slot(5), slot(2*int64(len(data))+1))
+	storage.SetState(contract, slotAt(slot(5).Bytes(), 0), common.BytesToHash(data[:32]))
+	storage.SetState(contract, slotAt(slot(5).Bytes(), 1), common.BytesToHash(common.RightPadBytes(data[32:], 32)))
+
+	storage.SetState(contract, slot(6), common.HexToHash("0x0000000000000000000000000000000600000000000000000000000000000005"))
+	storage.SetState(contract, slot(7), common.BytesToHash(owner.Bytes()))
+
+	// Mapping entries are stored at the hash of the key and the slot
+	balance := append(common.LeftPadBytes(owner.Bytes(), 32), slot(8).Bytes()...)
+	storage.SetState(contract, slotAt(balance, 0), slot(100))
+	inner := crypto.Keccak256(common.LeftPadBytes(owner.Bytes(), 32), slot(9).Bytes())
+	storage.SetState(contract, slotAt(append(common.LeftPadBytes(spender.Bytes(), 32), inner...), 0), slot(50))
+	named := append([]byte("x"), slot(10).Bytes()...)
+	storage.SetState(contract, slotAt(named, 0), slot(9))
+	storage.SetState(contract, slotAt(named, 1), common.BytesToHash(spender.Bytes()))
+
+	// Every struct of an array starts at a new slot
+	storage.SetState(contract, slot(13), slot(11))
+	storage.SetState(contract, slot(14), common.BytesToHash(spender.Bytes()))
+
+	layout := new(evm.StorageLayout)
+	if err := json.Unmarshal([]byte(testLayout), layout); err != nil {
+		t.Fatalf("Failed to parse layout: %v", err)
+	}
+	decoder := evm.NewStorageDecoder(storage, contract, layout, 10)
+	decoder.AddKeys("balances", strings.ToLower(owner.Hex()))
+	decoder.AddKeys("allowance", owner.Hex(), spender.Hex())
+	decoder.AddKeys("named", "x")
+	values, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Failed to decode storage: %v", err)
+	}
+	if len(values) != len(layout.Storage) {
+		t.Fatalf("Invalid number of variables, expected: %d, got: %d", len(layout.Storage), len(values))
+	}
+
+	flat := make(map[string]*evm.StorageValue)
+	flattenValues(values, flat)
+	expected := map[string]string{
+		"owner":                         owner.Hex(),
+		"paused":                        "true",
+		"decimals":                      "18",
+		"delta":                         "-2",
+		"total":                         "1000",
+		"small[0]":                      "1",
+		"small[1]":                      "2",
+		"small[2]":                      "3",
+		"list[0]":                       "7",
+		"list[1]":                       "8",
+		"name":                          `"goevm"`,
+		"data":                          hexutil.Encode(data),
+		"s.a":                           "5",
+		"s.b":                           "6",
+		"s.c":                           owner.Hex(),
+		"balances[" + owner.Hex() + "]": "100",
+		"allowance[" + owner.Hex() + "][" + spender.Hex() + "]": "50",
+		`named["x"].a`: "9",
+		`named["x"].b`: "0",
+		`named["x"].c`: spender.Hex(),
+		"pair[0].a":    "0",
+		"pair[1].a":    "11",
+		"pair[1].c":    spender.Hex(),
+	}
+	for name, want := range expected {
+		value, ok := flat[name]
+		if !ok {
+			t.Fatalf("Missing variable %s", name)
+		}
+		if value.Value != want {
+			t.Fatalf("Invalid value of %s, expected: %v, got: %v", name, want, value.Value)
+		}
+	}
+	if list := flat["list"]; list.Length != 2 || list.Truncated {
+		t.Fatalf("Invalid list, expected: length %d, got: %d (truncated: %v)", 2, list.Length, list.Truncated)
+	}
+
+	// Only the first elements of the arrays are read
+	values, err = evm.NewStorageDecoder(storage, contract, layout, 1).Decode()
+	if err != nil {
+		t.Fatalf("Failed to decode storage: %v", err)
+	}
+	flat = make(map[string]*evm.StorageValue)
+	flattenValues(values, flat)
+	if list := flat["list"]; !list.Truncated || len(list.Members) != 1 {
+		t.Fatalf("Invalid truncated list, expected: %d element, got: %d", 1, len(list.Members))
+	}
+	if _, ok := flat["balances["+owner.Hex()+"]"]; ok {
+		t.Fatalf("Invalid mapping entry without keys")
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"errors"
+	"goevm/evm"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestWitness(t *testing.T) {
+	path := newTestDatadir(t, "leveldb", rawdb.HashScheme, 3)
+
+	// Index the transaction of the last block so that it can be replayed
+	db := openTestDatabase(t, path)
+	block := rawdb.ReadBlock(db, rawdb.ReadCanonicalHash(db, 3), 3)
+	rawdb.WriteTxLookupEntriesByBlock(db, block)
+	db.Close()
+	hash := block.Transactions()[0].Hash()
+
+	storage, err := evm.NewRemoteStorage(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open remote storage: %v", err)
+	}
+	defer storage.Close()
+	config, err := storage.ChainConfig()
+	if err != nil {
+		t.Fatalf("Failed to read chain config: %v", err)
+	}
+
+	replay, err := evm.ReplayTransactionWithWitness(storage, hash, nil)
+	if err != nil {
+		t.Fatalf("Failed to replay transaction: %v", err)
+	}
+	witness := replay.Witness
+	if witness == nil || len(witness.State) == 0 || len(witness.Codes) != 1 {
+		t.Fatalf("Invalid witness, expected trie nodes and %d code, got: %v", 1, witness)
+	}
+	if witness.Root() != storage.GetHeader(block.ParentHash(), 2).Root {
+		t.Fatalf("Invalid witness root, got: %v", witness.Root())
+	}
+
+	// The transaction is re-executed from the decoded witness alone, with the
+	// same outcome as the replay on top of the database
+	encoded, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		t.Fatalf("Failed to encode witness: %v", err)
+	}
+	decoded := new(stateless.Witness)
+	if err := rlp.DecodeBytes(encoded, decoded); err != nil {
+		t.Fatalf("Failed to decode witness: %v", err)
+	}
+	result, err := evm.ExecuteWitness(config, decoded, hash, replay.Local, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute witness: %v", err)
+	}
+	if len(result.Diffs) != 0 {
+		t.Fatalf("Invalid witness execution, expected no diffs, got: %v", result.Diffs)
+	}
+
+	// A witness without the pre-state can't be opened
+	empty := witness.Copy()
+	empty.State = make(map[string]struct{})
+	if _, err := evm.NewWitnessStorage(empty, nil); err == nil {
+		t.Fatalf("Invalid witness storage without state, expected an error")
+	}
+
+	// A witness missing the code fails the execution with a storage error
+	noCode := witness.Copy()
+	noCode.Codes = make(map[string]struct{})
+	var storageErr *evm.StorageError
+	if _, err := evm.ExecuteWitness(config, noCode, hash, nil, nil); !errors.As(err, &storageErr) {
+		t.Fatalf("Invalid execution without code, expected: %T, got: %v", storageErr, err)
+	}
+}